@@ -0,0 +1,125 @@
+// Copyright (c) 2019 Tanner Ryan. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package naads
+
+import (
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/thetannerryan/cap"
+)
+
+// activeSet tracks the alerts currently in effect. Alerts are added as they
+// arrive, replaced by updates, removed by cancels and pruned once every Info
+// block has expired.
+type activeSet struct {
+	mu     sync.Mutex            // Mutex protecting alerts
+	alerts map[string]*cap.Alert // Active alerts keyed by identifier
+}
+
+// update applies the alert to the active set, returning the identifiers of the
+// alerts removed (superseded or cancelled) by it.
+func (s *activeSet) update(alert *cap.Alert) []string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.alerts == nil {
+		s.alerts = make(map[string]*cap.Alert)
+	}
+
+	var removed []string
+	if alert.MsgType == cap.MsgTypeUpdate || alert.MsgType == cap.MsgTypeCancel {
+		for _, id := range referencedIdentifiers(alert) {
+			if _, ok := s.alerts[id]; ok {
+				delete(s.alerts, id)
+				removed = append(removed, id)
+			}
+		}
+	}
+	if alert.MsgType != cap.MsgTypeCancel && !expired(alert, time.Now()) {
+		s.alerts[alert.Identifier] = alert
+	}
+	return removed
+}
+
+// prune removes the expired alerts, returning their identifiers.
+func (s *activeSet) prune(now time.Time) []string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	var removed []string
+	for id, alert := range s.alerts {
+		if expired(alert, now) {
+			delete(s.alerts, id)
+			removed = append(removed, id)
+		}
+	}
+	return removed
+}
+
+// list returns the active alerts, most recently sent first.
+func (s *activeSet) list() []*cap.Alert {
+	s.mu.Lock()
+	alerts := make([]*cap.Alert, 0, len(s.alerts))
+	for _, alert := range s.alerts {
+		alerts = append(alerts, alert)
+	}
+	s.mu.Unlock()
+	sort.Slice(alerts, func(i, j int) bool {
+		return alerts[i].Sent.Time().After(alerts[j].Sent.Time())
+	})
+	return alerts
+}
+
+// get returns the active alert with the identifier.
+func (s *activeSet) get(identifier string) (*cap.Alert, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	alert, ok := s.alerts[identifier]
+	return alert, ok
+}
+
+// Active returns the alerts currently in effect on the locked feed (Actual
+// alerts that have not been cancelled, superseded or expired), most recently
+// sent first.
+func (c *Client) Active() []*cap.Alert {
+	c.expire()
+	return c.active.list()
+}
+
+// referencedIdentifiers returns the identifiers of the messages referenced by
+// the alert. References are "sender,identifier,sent" triples.
+func referencedIdentifiers(alert *cap.Alert) []string {
+	var ids []string
+	for _, ref := range alert.References.Values() {
+		fields := strings.Split(ref, ",")
+		if len(fields) >= 2 && fields[1] != "" {
+			ids = append(ids, fields[1])
+		}
+	}
+	return ids
+}
+
+// expires returns the latest expiry time of the Info blocks of the alert. The
+// zero time is returned if any Info block does not expire.
+func expires(alert *cap.Alert) time.Time {
+	var latest time.Time
+	for i := range alert.Info {
+		t := alert.Info[i].Expires.Time()
+		if t.IsZero() {
+			return time.Time{}
+		}
+		if t.After(latest) {
+			latest = t
+		}
+	}
+	return latest
+}
+
+// expired returns true if every Info block of the alert has expired.
+func expired(alert *cap.Alert, now time.Time) bool {
+	t := expires(alert)
+	return !t.IsZero() && now.After(t)
+}
@@ -0,0 +1,196 @@
+// Copyright (c) 2019 Tanner Ryan. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package naads
+
+import (
+	"encoding/xml"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/thetannerryan/cap"
+)
+
+const feedTitle = "NAADS Alerts" // title of the Atom and RSS feeds
+
+// atomFeed is the root of an Atom (RFC 4287) feed.
+type atomFeed struct {
+	XMLName xml.Name    `xml:"http://www.w3.org/2005/Atom feed"`
+	Lang    string      `xml:"xml:lang,attr,omitempty"`
+	ID      string      `xml:"id"`
+	Title   string      `xml:"title"`
+	Updated string      `xml:"updated"`
+	Author  string      `xml:"author>name"`
+	Links   []atomLink  `xml:"link"`
+	Entries []atomEntry `xml:"entry"`
+}
+
+// atomLink is a link of a feed or entry.
+type atomLink struct {
+	Rel  string `xml:"rel,attr,omitempty"`
+	Type string `xml:"type,attr,omitempty"`
+	Href string `xml:"href,attr"`
+}
+
+// atomEntry is a single alert.
+type atomEntry struct {
+	ID         string         `xml:"id"`
+	Title      string         `xml:"title"`
+	Updated    string         `xml:"updated"`
+	Published  string         `xml:"published"`
+	Author     string         `xml:"author>name"`
+	Summary    string         `xml:"summary,omitempty"`
+	Categories []atomCategory `xml:"category"`
+	Links      []atomLink     `xml:"link"`
+}
+
+// atomCategory is a category of an entry.
+type atomCategory struct {
+	Term string `xml:"term,attr"`
+}
+
+// rssDocument is the root of an RSS 2.0 feed.
+type rssDocument struct {
+	XMLName xml.Name   `xml:"rss"`
+	Version string     `xml:"version,attr"`
+	Channel rssChannel `xml:"channel"`
+}
+
+// rssChannel is the channel of an RSS feed.
+type rssChannel struct {
+	Title         string    `xml:"title"`
+	Link          string    `xml:"link"`
+	Description   string    `xml:"description"`
+	Language      string    `xml:"language,omitempty"`
+	LastBuildDate string    `xml:"lastBuildDate"`
+	Items         []rssItem `xml:"item"`
+}
+
+// rssItem is a single alert.
+type rssItem struct {
+	Title       string   `xml:"title"`
+	Link        string   `xml:"link"`
+	Description string   `xml:"description,omitempty"`
+	Categories  []string `xml:"category"`
+	GUID        string   `xml:"guid"`
+	PubDate     string   `xml:"pubDate"`
+}
+
+// Atom converts the alerts into an Atom feed, with one entry per alert. Each
+// entry links to the original CAP XML of the alert at
+// <base>/alerts/<identifier>.xml. When a language is given (e.g. "fr"),
+// entries are written from the Info block in that language and alerts without
+// one are omitted; otherwise the primary (English) Info block is used.
+func Atom(base, lang string, alerts ...*cap.Alert) ([]byte, error) {
+	feed := &atomFeed{
+		Lang:    lang,
+		ID:      base + "/alerts.atom",
+		Title:   feedTitle,
+		Updated: time.Now().UTC().Format(time.RFC3339),
+		Author:  "NAADS",
+		Links:   []atomLink{{Rel: "self", Type: "application/atom+xml", Href: base + "/alerts.atom"}},
+	}
+	for _, alert := range alerts {
+		info := feedInfo(alert, lang)
+		if info == nil {
+			continue
+		}
+		link := alertURL(base, alert.Identifier)
+		entry := atomEntry{
+			ID:        link,
+			Title:     feedEntryTitle(info),
+			Updated:   alert.Sent.Time().UTC().Format(time.RFC3339),
+			Published: alert.Sent.Time().UTC().Format(time.RFC3339),
+			Author:    info.SenderName,
+			Summary:   info.Description,
+			Links:     []atomLink{{Rel: "alternate", Type: "application/cap+xml", Href: link}},
+		}
+		if entry.Author == "" {
+			entry.Author = alert.Sender
+		}
+		for _, term := range []string{info.Event, maxSeverity(alert).String(), info.Urgency.String()} {
+			if term != "" {
+				entry.Categories = append(entry.Categories, atomCategory{Term: term})
+			}
+		}
+		feed.Entries = append(feed.Entries, entry)
+	}
+
+	data, err := xml.MarshalIndent(feed, "", "  ")
+	if err != nil {
+		return nil, err
+	}
+	return append([]byte(xml.Header), data...), nil
+}
+
+// RSS converts the alerts into an RSS 2.0 feed, with one item per alert. Items
+// are written as the entries of Atom.
+func RSS(base, lang string, alerts ...*cap.Alert) ([]byte, error) {
+	doc := &rssDocument{
+		Version: "2.0",
+		Channel: rssChannel{
+			Title:         feedTitle,
+			Link:          base + "/",
+			Description:   "Alerts currently in effect",
+			Language:      lang,
+			LastBuildDate: time.Now().UTC().Format(time.RFC1123Z),
+		},
+	}
+	for _, alert := range alerts {
+		info := feedInfo(alert, lang)
+		if info == nil {
+			continue
+		}
+		link := alertURL(base, alert.Identifier)
+		item := rssItem{
+			Title:       feedEntryTitle(info),
+			Link:        link,
+			Description: info.Description,
+			GUID:        link,
+			PubDate:     alert.Sent.Time().UTC().Format(time.RFC1123Z),
+		}
+		if info.Event != "" {
+			item.Categories = append(item.Categories, info.Event)
+		}
+		doc.Channel.Items = append(doc.Channel.Items, item)
+	}
+
+	data, err := xml.MarshalIndent(doc, "", "  ")
+	if err != nil {
+		return nil, err
+	}
+	return append([]byte(xml.Header), data...), nil
+}
+
+// feedInfo returns the Info block of the alert in the language (matched by
+// prefix, so "fr" matches "fr-CA"), or the primary Info block if no language
+// is given. Nil is returned if there is no such block.
+func feedInfo(alert *cap.Alert, lang string) *cap.Info {
+	if len(alert.Info) == 0 {
+		return nil
+	}
+	if lang == "" {
+		return primaryInfo(alert)
+	}
+	for i := range alert.Info {
+		if strings.HasPrefix(strings.ToLower(alert.Info[i].Language), strings.ToLower(lang)) {
+			return &alert.Info[i]
+		}
+	}
+	return nil
+}
+
+// feedEntryTitle returns the title of the entry of the Info block.
+func feedEntryTitle(info *cap.Info) string {
+	if info.Headline != "" {
+		return info.Headline
+	}
+	return info.Event
+}
+
+// alertURL returns the URL of the original CAP XML of the alert.
+func alertURL(base, identifier string) string {
+	return base + "/alerts/" + url.PathEscape(identifier) + ".xml"
+}
@@ -0,0 +1,249 @@
+// Copyright (c) 2019 Tanner Ryan. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package naads
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"strconv"
+	"time"
+)
+
+// AudioInfo describes the audio content of a resource.
+type AudioInfo struct {
+	Format        string        // Container format ("WAV" or "MP3")
+	Encoding      string        // Encoding of the audio (e.g. "PCM", "MPEG-1 Layer III")
+	SampleRate    int           // Sample rate in Hz
+	Channels      int           // Number of channels
+	BitsPerSample int           // Bits per sample (0 for compressed audio)
+	Bitrate       int           // Average bitrate in bits per second
+	Duration      time.Duration // Duration of the audio
+}
+
+// InspectAudio reads the headers of WAV or MP3 audio, returning its format
+// and duration.
+func InspectAudio(data []byte) (*AudioInfo, error) {
+	switch SniffMIME(data) {
+	case "audio/wav":
+		return inspectWAV(data)
+	case "audio/mpeg":
+		return inspectMP3(data)
+	}
+	return nil, errors.New("Error: unsupported audio format")
+}
+
+// wavEncodings are the names of the common WAV format tags.
+var wavEncodings = map[uint16]string{
+	1:      "PCM",
+	3:      "IEEE float",
+	6:      "A-law",
+	7:      "mu-law",
+	0x55:   "MPEG Layer III",
+	0xFFFE: "PCM (extensible)",
+}
+
+// inspectWAV reads the fmt and data chunks of a RIFF WAVE file.
+func inspectWAV(data []byte) (*AudioInfo, error) {
+	if len(data) < 12 || !bytes.HasPrefix(data, []byte("RIFF")) || !bytes.Equal(data[8:12], []byte("WAVE")) {
+		return nil, errors.New("Error: not a WAV file")
+	}
+	info := &AudioInfo{Format: "WAV"}
+	var byteRate, dataSize uint32
+	haveFmt, haveData := false, false
+
+	for pos := 12; pos+8 <= len(data); {
+		id := string(data[pos : pos+4])
+		size := binary.LittleEndian.Uint32(data[pos+4 : pos+8])
+		body := data[pos+8:]
+		switch id {
+		case "fmt ":
+			if size < 16 || len(body) < 16 {
+				return nil, errors.New("Error: truncated WAV fmt chunk")
+			}
+			tag := binary.LittleEndian.Uint16(body[0:2])
+			info.Encoding = wavEncodings[tag]
+			if info.Encoding == "" {
+				info.Encoding = "format " + strconv.Itoa(int(tag))
+			}
+			info.Channels = int(binary.LittleEndian.Uint16(body[2:4]))
+			info.SampleRate = int(binary.LittleEndian.Uint32(body[4:8]))
+			byteRate = binary.LittleEndian.Uint32(body[8:12])
+			info.BitsPerSample = int(binary.LittleEndian.Uint16(body[14:16]))
+			haveFmt = true
+		case "data":
+			// streamed files may not declare the size of the data
+			dataSize = size
+			if uint64(size) > uint64(len(body)) {
+				dataSize = uint32(len(body))
+			}
+			haveData = true
+		}
+		if haveFmt && haveData {
+			break
+		}
+		// chunks are padded to an even size
+		next := uint64(pos) + 8 + uint64(size) + uint64(size&1)
+		if next > uint64(len(data)) {
+			break
+		}
+		pos = int(next)
+	}
+
+	if !haveFmt {
+		return nil, errors.New("Error: WAV file has no fmt chunk")
+	}
+	if !haveData {
+		return nil, errors.New("Error: WAV file has no data chunk")
+	}
+	if byteRate == 0 {
+		return nil, errors.New("Error: WAV file has a zero byte rate")
+	}
+	info.Bitrate = int(byteRate) * 8
+	info.Duration = time.Duration(float64(dataSize) / float64(byteRate) * float64(time.Second))
+	return info, nil
+}
+
+// mp3Bitrates are the bitrates (kbps) indexed by MPEG version (1 or 2/2.5),
+// layer and bitrate index.
+var mp3Bitrates = [2][3][15]int{
+	{ // MPEG-1
+		{0, 32, 64, 96, 128, 160, 192, 224, 256, 288, 320, 352, 384, 416, 448},
+		{0, 32, 48, 56, 64, 80, 96, 112, 128, 160, 192, 224, 256, 320, 384},
+		{0, 32, 40, 48, 56, 64, 80, 96, 112, 128, 160, 192, 224, 256, 320},
+	},
+	{ // MPEG-2 and MPEG-2.5
+		{0, 32, 48, 56, 64, 80, 96, 112, 128, 144, 160, 176, 192, 224, 256},
+		{0, 8, 16, 24, 32, 40, 48, 56, 64, 80, 96, 112, 128, 144, 160},
+		{0, 8, 16, 24, 32, 40, 48, 56, 64, 80, 96, 112, 128, 144, 160},
+	},
+}
+
+// mp3SampleRates are the sample rates indexed by MPEG version bits and sample
+// rate index.
+var mp3SampleRates = map[byte][3]int{
+	3: {44100, 48000, 32000}, // MPEG-1
+	2: {22050, 24000, 16000}, // MPEG-2
+	0: {11025, 12000, 8000},  // MPEG-2.5
+}
+
+// mp3Frame is the header of an MPEG audio frame.
+type mp3Frame struct {
+	version    string // MPEG version ("1", "2" or "2.5")
+	layer      int    // Layer (1, 2 or 3)
+	bitrate    int    // Bitrate in bits per second
+	sampleRate int    // Sample rate in Hz
+	channels   int    // Number of channels
+	samples    int    // Samples per frame
+	length     int    // Length of the frame in bytes
+}
+
+// parseMP3Frame parses the frame header at the start of the data.
+func parseMP3Frame(data []byte) (*mp3Frame, bool) {
+	if len(data) < 4 || data[0] != 0xFF || data[1]&0xE0 != 0xE0 {
+		return nil, false
+	}
+	versionBits := (data[1] >> 3) & 3
+	layerBits := (data[1] >> 1) & 3
+	bitrateIndex := data[2] >> 4
+	rateIndex := (data[2] >> 2) & 3
+	padding := int((data[2] >> 1) & 1)
+	if versionBits == 1 || layerBits == 0 || bitrateIndex == 0 || bitrateIndex == 15 || rateIndex == 3 {
+		// reserved values (and free format, which cannot be framed)
+		return nil, false
+	}
+
+	f := &mp3Frame{layer: int(4 - layerBits), channels: 2}
+	v := 1
+	switch versionBits {
+	case 3:
+		f.version, v = "1", 0
+	case 2:
+		f.version = "2"
+	default:
+		f.version = "2.5"
+	}
+	f.bitrate = mp3Bitrates[v][f.layer-1][bitrateIndex] * 1000
+	f.sampleRate = mp3SampleRates[versionBits][rateIndex]
+	if data[3]>>6 == 3 {
+		f.channels = 1
+	}
+
+	switch {
+	case f.layer == 1:
+		f.samples = 384
+		f.length = (12*f.bitrate/f.sampleRate + padding) * 4
+	case f.layer == 3 && v == 1:
+		f.samples = 576
+		f.length = 72*f.bitrate/f.sampleRate + padding
+	default:
+		f.samples = 1152
+		f.length = 144*f.bitrate/f.sampleRate + padding
+	}
+	return f, f.length > 4
+}
+
+// inspectMP3 walks the frames of MPEG audio, summing their durations (which
+// is accurate for both constant and variable bitrate files).
+func inspectMP3(data []byte) (*AudioInfo, error) {
+	pos := 0
+	// skip ID3v2 tags
+	for len(data)-pos >= 10 && bytes.HasPrefix(data[pos:], []byte("ID3")) {
+		flags := data[pos+5]
+		size := int(data[pos+6]&0x7F)<<21 | int(data[pos+7]&0x7F)<<14 | int(data[pos+8]&0x7F)<<7 | int(data[pos+9]&0x7F)
+		pos += 10 + size
+		if flags&0x10 != 0 {
+			// footer present
+			pos += 10
+		}
+	}
+
+	var info *AudioInfo
+	var samples, bytesTotal int64
+	for pos+4 <= len(data) {
+		f, ok := parseMP3Frame(data[pos:])
+		if !ok {
+			// resynchronize on the next frame header
+			pos++
+			continue
+		}
+		if pos+f.length > len(data) {
+			if info != nil {
+				// truncated final frame
+				break
+			}
+			pos++
+			continue
+		}
+		// the first frame must be followed by another frame (or the end of
+		// the data) to avoid locking onto a false frame sync
+		if info == nil && pos+f.length+4 <= len(data) {
+			if _, ok := parseMP3Frame(data[pos+f.length:]); !ok {
+				pos++
+				continue
+			}
+		}
+		if info == nil {
+			info = &AudioInfo{
+				Format:     "MP3",
+				Encoding:   "MPEG-" + f.version + " Layer " + [...]string{"I", "II", "III"}[f.layer-1],
+				SampleRate: f.sampleRate,
+				Channels:   f.channels,
+			}
+		}
+		samples += int64(f.samples)
+		bytesTotal += int64(f.length)
+		pos += f.length
+	}
+
+	if info == nil {
+		return nil, errors.New("Error: MP3 file has no audio frames")
+	}
+	info.Duration = time.Duration(samples * int64(time.Second) / int64(info.SampleRate))
+	if info.Duration > 0 {
+		info.Bitrate = int(float64(bytesTotal*8) / info.Duration.Seconds())
+	}
+	return info, nil
+}
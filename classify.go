@@ -0,0 +1,188 @@
+// Copyright (c) 2019 Tanner Ryan. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package naads
+
+import (
+	"github.com/thetannerryan/cap"
+)
+
+const heartbeatSender = "NAADS-Heartbeat" // sender of NAADS heartbeat messages
+
+// Class is the classification of a NAADS message, derived from its status,
+// message type and sender.
+type Class int
+
+const (
+	// ClassAlert :: Actual alert, update or cancel
+	ClassAlert Class = 0
+	// ClassHeartbeat :: NAADS system heartbeat
+	ClassHeartbeat Class = 1
+	// ClassTest :: Technical test message
+	ClassTest Class = 2
+	// ClassExercise :: Message for designated exercise participants only
+	ClassExercise Class = 3
+	// ClassDraft :: Preliminary template or draft, not actionable
+	ClassDraft Class = 4
+	// ClassSystem :: Alert network internal message (other than heartbeats)
+	ClassSystem Class = 5
+	// ClassAck :: Acknowledgement of earlier messages
+	ClassAck Class = 6
+	// ClassError :: Rejection of earlier messages
+	ClassError Class = 7
+	// ClassUnknown :: Malformed message that could not be parsed
+	ClassUnknown Class = 8
+
+	classCount = 9 // number of message classes
+)
+
+// Class mapping (display names, ordered by code)
+var classNames = [classCount]string{
+	"ALERT",
+	"HEARTBEAT",
+	"TEST",
+	"EXERCISE",
+	"DRAFT",
+	"SYSTEM",
+	"ACK",
+	"ERROR",
+	"UNKNOWN",
+}
+
+// String returns the display name of the Class.
+func (c Class) String() string {
+	if c < 0 || c >= classCount {
+		return "UNKNOWN"
+	}
+	return classNames[c]
+}
+
+// ClassRule overrides the default classification for messages matching all of
+// its non-empty fields. Rules are evaluated in order, and the first matching
+// rule determines the class of the message.
+type ClassRule struct {
+	Sender   string        // Sender of the message (empty matches any sender)
+	Statuses []cap.Status  // Statuses matched by the rule (empty matches any status)
+	MsgTypes []cap.MsgType // Message types matched by the rule (empty matches any type)
+	Class    Class         // Class assigned to matching messages
+}
+
+// matches returns true if the alert satisfies every condition of the rule.
+func (r *ClassRule) matches(alert *cap.Alert) bool {
+	if r.Sender != "" && r.Sender != alert.Sender {
+		return false
+	}
+	if len(r.Statuses) > 0 {
+		found := false
+		for _, s := range r.Statuses {
+			if s == alert.Status {
+				found = true
+				break
+			}
+		}
+		if !found {
+			return false
+		}
+	}
+	if len(r.MsgTypes) > 0 {
+		found := false
+		for _, t := range r.MsgTypes {
+			if t == alert.MsgType {
+				found = true
+				break
+			}
+		}
+		if !found {
+			return false
+		}
+	}
+	return true
+}
+
+// Classify returns the class of the alert. The rules are consulted first; if
+// none match, the default classification is used: heartbeats are identified by
+// their sender, Test/Exercise/Draft statuses take precedence over the message
+// type, Ack/Error message types come next, and remaining System messages are
+// classed as System. Everything else (Actual Alert/Update/Cancel) is an alert.
+// A rule assigning an undefined class classifies the message as Unknown.
+func Classify(alert *cap.Alert, rules []ClassRule) Class {
+	for i := range rules {
+		if rules[i].matches(alert) {
+			if rules[i].Class < 0 || rules[i].Class >= classCount {
+				return ClassUnknown
+			}
+			return rules[i].Class
+		}
+	}
+
+	if alert.Status == cap.StatusSystem && alert.Sender == heartbeatSender {
+		return ClassHeartbeat
+	}
+	switch alert.Status {
+	case cap.StatusTest:
+		return ClassTest
+	case cap.StatusExercise:
+		return ClassExercise
+	case cap.StatusDraft:
+		return ClassDraft
+	}
+	switch alert.MsgType {
+	case cap.MsgTypeAck:
+		return ClassAck
+	case cap.MsgTypeError:
+		return ClassError
+	}
+	if alert.Status == cap.StatusSystem {
+		return ClassSystem
+	}
+	return ClassAlert
+}
+
+// Route is the forwarding policy the client applies to a message class.
+type Route int
+
+const (
+	// RouteForward :: Forward messages to the output channel
+	RouteForward Route = 0
+	// RouteDrop :: Silently discard messages
+	RouteDrop Route = 1
+	// RouteLog :: Discard messages, logging them if LogControl is enabled
+	RouteLog Route = 2
+)
+
+// String returns the display name of the Route.
+func (r Route) String() string {
+	switch r {
+	case RouteForward:
+		return "FORWARD"
+	case RouteDrop:
+		return "DROP"
+	case RouteLog:
+		return "LOG"
+	}
+	return "UNKNOWN"
+}
+
+// defaultRoutes are the routes applied to classes not present in
+// Client.Routes. Actual alerts and tests are forwarded (heartbeats are further
+// subject to Feed.SendHeartbeat); everything else is dropped.
+var defaultRoutes = map[Class]Route{
+	ClassAlert:     RouteForward,
+	ClassHeartbeat: RouteForward,
+	ClassTest:      RouteForward,
+	ClassExercise:  RouteDrop,
+	ClassDraft:     RouteDrop,
+	ClassSystem:    RouteDrop,
+	ClassAck:       RouteDrop,
+	ClassError:     RouteDrop,
+	ClassUnknown:   RouteDrop,
+}
+
+// route returns the forwarding policy of the class for the client.
+func (c *Client) route(class Class) Route {
+	if r, ok := c.Routes[class]; ok {
+		return r
+	}
+	return defaultRoutes[class]
+}
@@ -0,0 +1,359 @@
+// Copyright (c) 2019 Tanner Ryan. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package naads
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/thetannerryan/cap"
+)
+
+const defaultDatabaseDSN = "naads.db" // default data source name
+
+// DatabaseDialect is the placeholder style of the statements written by a
+// Database.
+type DatabaseDialect int
+
+const (
+	// DialectQuestion :: Placeholders written as ? (SQLite, MySQL)
+	DialectQuestion DatabaseDialect = 0
+	// DialectDollar :: Placeholders written as $N (PostgreSQL)
+	DialectDollar DatabaseDialect = 1
+)
+
+// String returns the display name of the DatabaseDialect.
+func (d DatabaseDialect) String() string {
+	switch d {
+	case DialectQuestion:
+		return "QUESTION"
+	case DialectDollar:
+		return "DOLLAR"
+	}
+	return "UNKNOWN"
+}
+
+// databaseMigrations are the statements upgrading the schema, indexed by
+// schema version - 1. Applied versions are recorded in naads_schema.
+var databaseMigrations = [][]string{
+	{
+		`CREATE TABLE alerts (
+			identifier TEXT NOT NULL PRIMARY KEY,
+			sender TEXT NOT NULL,
+			sent TEXT,
+			status TEXT NOT NULL,
+			msg_type TEXT NOT NULL,
+			scope TEXT NOT NULL,
+			source TEXT,
+			note TEXT,
+			refs TEXT,
+			class TEXT NOT NULL,
+			feed TEXT,
+			received TEXT,
+			raw TEXT
+		)`,
+		`CREATE INDEX alerts_sent ON alerts (sent)`,
+		`CREATE TABLE infos (
+			alert_identifier TEXT NOT NULL,
+			info_index INTEGER NOT NULL,
+			language TEXT,
+			event TEXT,
+			urgency TEXT,
+			severity TEXT,
+			certainty TEXT,
+			effective TEXT,
+			onset TEXT,
+			expires TEXT,
+			sender_name TEXT,
+			headline TEXT,
+			description TEXT,
+			instruction TEXT,
+			web TEXT,
+			PRIMARY KEY (alert_identifier, info_index)
+		)`,
+		`CREATE TABLE event_codes (
+			alert_identifier TEXT NOT NULL,
+			info_index INTEGER NOT NULL,
+			value_name TEXT,
+			value TEXT
+		)`,
+		`CREATE INDEX event_codes_alert ON event_codes (alert_identifier)`,
+		`CREATE TABLE parameters (
+			alert_identifier TEXT NOT NULL,
+			info_index INTEGER NOT NULL,
+			value_name TEXT,
+			value TEXT
+		)`,
+		`CREATE INDEX parameters_alert ON parameters (alert_identifier)`,
+		`CREATE TABLE areas (
+			alert_identifier TEXT NOT NULL,
+			info_index INTEGER NOT NULL,
+			area_index INTEGER NOT NULL,
+			area_desc TEXT,
+			polygon TEXT,
+			circles TEXT,
+			altitude REAL,
+			ceiling REAL,
+			PRIMARY KEY (alert_identifier, info_index, area_index)
+		)`,
+		`CREATE TABLE geocodes (
+			alert_identifier TEXT NOT NULL,
+			info_index INTEGER NOT NULL,
+			area_index INTEGER NOT NULL,
+			value_name TEXT,
+			value TEXT
+		)`,
+		`CREATE INDEX geocodes_alert ON geocodes (alert_identifier)`,
+		`CREATE INDEX geocodes_value ON geocodes (value)`,
+		`CREATE TABLE resources (
+			alert_identifier TEXT NOT NULL,
+			info_index INTEGER NOT NULL,
+			resource_index INTEGER NOT NULL,
+			resource_desc TEXT,
+			mime_type TEXT,
+			size INTEGER,
+			uri TEXT,
+			digest TEXT,
+			PRIMARY KEY (alert_identifier, info_index, resource_index)
+		)`,
+	},
+}
+
+// databaseChildTables are the tables holding the rows of an alert (other
+// than the alerts table), deleted when the alert is replaced.
+var databaseChildTables = []string{"infos", "event_codes", "parameters", "areas", "geocodes", "resources"}
+
+// Database is a sink writing alerts to a relational database through
+// database/sql, in a normalized schema of alerts, infos, event codes,
+// parameters, areas, geocodes and resource metadata (embedded resource data
+// is not stored). The schema is created and migrated automatically.
+// Receiving an alert with an identifier already stored replaces it.
+//
+// There is no default driver: this package has no dependencies beyond the
+// CAP package, so no driver is linked, and a Database without a Driver or DB
+// fails every delivery permanently. The application imports the driver of its
+// database and names it in Driver (or opens DB itself), for example with the
+// pure Go SQLite driver:
+//
+//	import _ "modernc.org/sqlite"
+//
+//	&naads.Database{Driver: "sqlite", DSN: "naads.db"}
+//
+// The statements use standard SQL; the placeholder style of the driver is
+// given by Dialect, which must be DialectDollar for PostgreSQL:
+//
+//	&naads.Database{Driver: "pgx", DSN: "postgres://...", Dialect: naads.DialectDollar}
+//
+// A database that cannot be opened or migrated is retried on the next
+// delivery.
+type Database struct {
+	DB      *sql.DB         // Open database (nil opens Driver with DSN)
+	Driver  string          // Name of the database/sql driver (REQUIRED if DB is nil; no driver is linked by default)
+	DSN     string          // Data source name (defaults to "naads.db")
+	Dialect DatabaseDialect // Placeholder style of the driver (defaults to DialectQuestion)
+	Raw     bool            // Indicator to store the original XML of each alert
+
+	mu    sync.Mutex // Mutex protecting the opening of the database
+	ready bool       // Indicator that the database is open and migrated
+}
+
+// Deliver writes the alert, replacing any stored alert with its identifier.
+func (d *Database) Deliver(ctx context.Context, msg *Message) error {
+	d.mu.Lock()
+	if !d.ready {
+		if err := d.open(); err != nil {
+			d.mu.Unlock()
+			return err
+		}
+		d.ready = true
+	}
+	d.mu.Unlock()
+
+	tx, err := d.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	if err := d.write(ctx, tx, msg); err != nil {
+		tx.Rollback()
+		return err
+	}
+	return tx.Commit()
+}
+
+// Close closes the database.
+func (d *Database) Close() error {
+	if d.DB == nil {
+		return nil
+	}
+	return d.DB.Close()
+}
+
+// open opens the database (if necessary) and migrates the schema.
+func (d *Database) open() error {
+	if d.Dialect != DialectQuestion && d.Dialect != DialectDollar {
+		return Permanent(errors.New("Error: unknown database dialect " + strconv.Itoa(int(d.Dialect))))
+	}
+	if d.DB == nil {
+		if d.Driver == "" {
+			return Permanent(errors.New("Error: database driver is not set (no driver is linked by default)"))
+		}
+		dsn := d.DSN
+		if dsn == "" {
+			dsn = defaultDatabaseDSN
+		}
+		db, err := sql.Open(d.Driver, dsn)
+		if err != nil {
+			// the driver is not registered
+			return Permanent(err)
+		}
+		d.DB = db
+	}
+	return d.migrate()
+}
+
+// migrate applies the migrations newer than the version of the schema.
+func (d *Database) migrate() error {
+	if _, err := d.DB.Exec(`CREATE TABLE IF NOT EXISTS naads_schema (version INTEGER NOT NULL)`); err != nil {
+		return err
+	}
+	var version sql.NullInt64
+	if err := d.DB.QueryRow(`SELECT MAX(version) FROM naads_schema`).Scan(&version); err != nil {
+		return err
+	}
+	for v := int(version.Int64); v < len(databaseMigrations); v++ {
+		tx, err := d.DB.Begin()
+		if err != nil {
+			return err
+		}
+		for _, stmt := range databaseMigrations[v] {
+			if _, err := tx.Exec(stmt); err != nil {
+				tx.Rollback()
+				return err
+			}
+		}
+		if _, err := tx.Exec(d.query(`INSERT INTO naads_schema (version) VALUES (?)`), v+1); err != nil {
+			tx.Rollback()
+			return err
+		}
+		if err := tx.Commit(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// write replaces the rows of the alert within the transaction.
+func (d *Database) write(ctx context.Context, tx *sql.Tx, msg *Message) error {
+	alert := msg.Alert
+	exec := func(query string, args ...interface{}) error {
+		_, err := tx.ExecContext(ctx, d.query(query), args...)
+		return err
+	}
+
+	// remove the stored alert
+	for _, table := range append([]string{"alerts"}, databaseChildTables...) {
+		column := "alert_identifier"
+		if table == "alerts" {
+			column = "identifier"
+		}
+		if err := exec(`DELETE FROM `+table+` WHERE `+column+` = ?`, alert.Identifier); err != nil {
+			return err
+		}
+	}
+
+	var raw interface{}
+	if d.Raw {
+		raw = string(msg.Raw)
+	}
+	var received interface{}
+	if !msg.Received.IsZero() {
+		received = msg.Received.UTC().Format(time.RFC3339)
+	}
+	if err := exec(`INSERT INTO alerts (identifier, sender, sent, status, msg_type, scope, source, note, refs, class, feed, received, raw)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)`,
+		alert.Identifier, alert.Sender, databaseTime(alert.Sent), alert.Status.String(), alert.MsgType.String(),
+		alert.Scope.String(), alert.Source, alert.Note, alert.References.String(), msg.Class.String(), msg.Feed, received, raw); err != nil {
+		return err
+	}
+
+	for i := range alert.Info {
+		info := &alert.Info[i]
+		if err := exec(`INSERT INTO infos (alert_identifier, info_index, language, event, urgency, severity, certainty,
+			effective, onset, expires, sender_name, headline, description, instruction, web)
+			VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)`,
+			alert.Identifier, i, info.Language, info.Event, info.Urgency.String(), info.Severity.String(), info.Certainty.String(),
+			databaseTime(info.Effective), databaseTime(info.Onset), databaseTime(info.Expires),
+			info.SenderName, info.Headline, info.Description, info.Instruction, info.Web); err != nil {
+			return err
+		}
+		for _, code := range info.EventCode {
+			if err := exec(`INSERT INTO event_codes (alert_identifier, info_index, value_name, value) VALUES (?, ?, ?, ?)`,
+				alert.Identifier, i, code.ValueName, code.Value); err != nil {
+				return err
+			}
+		}
+		for _, param := range info.Parameter {
+			if err := exec(`INSERT INTO parameters (alert_identifier, info_index, value_name, value) VALUES (?, ?, ?, ?)`,
+				alert.Identifier, i, param.ValueName, param.Value); err != nil {
+				return err
+			}
+		}
+		for j := range info.Area {
+			area := &info.Area[j]
+			if err := exec(`INSERT INTO areas (alert_identifier, info_index, area_index, area_desc, polygon, circles, altitude, ceiling)
+				VALUES (?, ?, ?, ?, ?, ?, ?, ?)`,
+				alert.Identifier, i, j, area.AreaDesc, area.Polygon.String(), strings.Join(area.Circle, ";"),
+				float64(area.Altitude), float64(area.Ceiling)); err != nil {
+				return err
+			}
+			for _, geocode := range area.Geocode {
+				if err := exec(`INSERT INTO geocodes (alert_identifier, info_index, area_index, value_name, value) VALUES (?, ?, ?, ?, ?)`,
+					alert.Identifier, i, j, geocode.ValueName, geocode.Value); err != nil {
+					return err
+				}
+			}
+		}
+		for j, res := range info.Resource {
+			if err := exec(`INSERT INTO resources (alert_identifier, info_index, resource_index, resource_desc, mime_type, size, uri, digest)
+				VALUES (?, ?, ?, ?, ?, ?, ?, ?)`,
+				alert.Identifier, i, j, res.ResourceDesc, res.MimeType, res.Size, res.URI, res.Digest); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// query rewrites the ? placeholders of the query for the dialect.
+func (d *Database) query(query string) string {
+	if d.Dialect != DialectDollar {
+		return query
+	}
+	var b strings.Builder
+	n := 0
+	for _, r := range query {
+		if r == '?' {
+			n++
+			b.WriteString("$" + strconv.Itoa(n))
+			continue
+		}
+		b.WriteRune(r)
+	}
+	return b.String()
+}
+
+// databaseTime returns the time as UTC RFC 3339 text, or NULL if it is not
+// set.
+func databaseTime(t cap.DateTime) interface{} {
+	if t.Time().IsZero() {
+		return nil
+	}
+	return t.Time().UTC().Format(time.RFC3339)
+}
@@ -0,0 +1,384 @@
+// Copyright (c) 2019 Tanner Ryan. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package naads
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"crypto/tls"
+	"encoding/hex"
+	"errors"
+	htmltemplate "html/template"
+	"mime"
+	"mime/multipart"
+	"mime/quotedprintable"
+	"net"
+	"net/smtp"
+	"net/textproto"
+	"strings"
+	"sync"
+	"text/template"
+	"time"
+
+	"github.com/thetannerryan/cap"
+)
+
+const emailResultsSize = 100 // number of delivery results retained
+
+// emailHeaderValue removes line breaks from header values.
+var emailHeaderValue = strings.NewReplacer("\r", "", "\n", "")
+
+// default email templates (English and French Info blocks)
+const (
+	defaultEmailSubject = `{{with .English}}{{.Headline}}{{else}}{{with .French}}{{.Headline}}{{end}}{{end}}{{with .French}}{{with $.English}} / {{end}}{{.Headline}}{{end}}`
+	defaultEmailText    = `{{range .Infos}}{{.Headline}}
+
+{{.Description}}
+{{if .Instruction}}
+{{.Instruction}}
+{{end}}
+{{range .Area}}- {{.AreaDesc}}
+{{end}}
+{{end}}Identifier: {{.Alert.Identifier}}
+Sent: {{.Alert.Sent}}
+`
+	defaultEmailHTML = `<!DOCTYPE html>
+<html><body>{{range .Infos}}<div lang="{{.Language}}">
+<h2>{{.Headline}}</h2>
+<p>{{.Description}}</p>
+{{if .Instruction}}<p><strong>{{.Instruction}}</strong></p>{{end}}
+<ul>{{range .Area}}<li>{{.AreaDesc}}</li>{{end}}</ul>
+</div><hr>{{end}}
+<p><small>Identifier: {{.Alert.Identifier}}<br>Sent: {{.Alert.Sent}}</small></p>
+</body></html>`
+)
+
+// EmailGroup is a group of recipients receiving the alerts matched by its
+// filter. Each group receives a single email per alert.
+type EmailGroup struct {
+	Name   string   // Name of the group (display purposes)
+	Filter Filter   // Filter selecting the alerts emailed (nil emails all alerts)
+	To     []string // Addresses of the recipients
+}
+
+// EmailResult is the result of emailing an alert to a group.
+type EmailResult struct {
+	Identifier string    // Identifier of the alert
+	Group      string    // Name of the group
+	Recipients int       // Number of recipients
+	Time       time.Time // Time of the delivery attempt
+	Err        error     // Error of the delivery (nil if delivered)
+}
+
+// EmailData is the data available to the email templates.
+type EmailData struct {
+	Alert   *cap.Alert  // Alert being emailed
+	Class   Class       // Classification of the alert
+	English *cap.Info   // English Info block (nil if absent)
+	French  *cap.Info   // French Info block (nil if absent)
+	Infos   []*cap.Info // English and French Info blocks (every Info block if neither is present)
+}
+
+// Email is a sink emailing alerts over SMTP. The subject and the text and
+// HTML bodies are rendered from templates (text/template and html/template)
+// with EmailData; the defaults present the English and French Info blocks.
+// STARTTLS is used when offered by the server, and PLAIN authentication is
+// only performed over TLS (or to localhost).
+//
+// Recipients are emailed in one message per group, addressed to
+// undisclosed recipients. When a delivery is retried, groups that were
+// already emailed are skipped.
+type Email struct {
+	Host       string        // Address of the SMTP server (host:port)
+	Username   string        // Username of PLAIN authentication (empty disables authentication)
+	Password   string        // Password of PLAIN authentication
+	From       string        // Address of the sender
+	Groups     []*EmailGroup // Recipient groups
+	TLSConfig  *tls.Config   // Configuration of STARTTLS (defaults to verifying the server host name)
+	RequireTLS bool          // Indicator to fail delivery if the server does not offer STARTTLS
+	Subject    string        // Subject template (defaults to the English and French headlines)
+	Text       string        // Text body template
+	HTML       string        // HTML body template (empty HTML and Text use the defaults; set only Text for a text-only email)
+
+	once      sync.Once               // Ensures the templates are parsed once
+	err       error                   // Error encountered parsing the templates
+	subject   *template.Template      // Parsed subject template
+	text      *template.Template      // Parsed text template
+	html      *htmltemplate.Template  // Parsed HTML template (nil for text-only email)
+	mu        sync.Mutex              // Mutex protecting the results
+	results   []EmailResult           // Recent delivery results (oldest first)
+	delivered map[string]map[int]bool // Groups already emailed per alert identifier (pending retries)
+}
+
+// Deliver emails the alert to every group whose filter matches it.
+func (e *Email) Deliver(ctx context.Context, msg *Message) error {
+	if err := e.parse(); err != nil {
+		return Permanent(err)
+	}
+	subject, body, err := e.render(msg)
+	if err != nil {
+		return Permanent(err)
+	}
+
+	var failed error
+	for i, group := range e.Groups {
+		if len(group.To) == 0 || (group.Filter != nil && !group.Filter.Match(msg.Alert)) || e.done(msg, i) {
+			continue
+		}
+		err := e.send(ctx, group.To, e.message(msg, subject, body))
+		e.record(EmailResult{
+			Identifier: msg.Alert.Identifier,
+			Group:      group.Name,
+			Recipients: len(group.To),
+			Time:       time.Now(),
+			Err:        err,
+		})
+		if err != nil {
+			failed = err
+			continue
+		}
+		e.markDone(msg, i)
+	}
+	if failed != nil {
+		return failed
+	}
+	e.mu.Lock()
+	delete(e.delivered, msg.Alert.Identifier)
+	e.mu.Unlock()
+	return nil
+}
+
+// Results returns the recent delivery results, oldest first.
+func (e *Email) Results() []EmailResult {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	return append([]EmailResult(nil), e.results...)
+}
+
+// parse parses the templates of the sink.
+func (e *Email) parse() error {
+	e.once.Do(func() {
+		subject, text, html := e.Subject, e.Text, e.HTML
+		if subject == "" {
+			subject = defaultEmailSubject
+		}
+		if text == "" && html == "" {
+			text, html = defaultEmailText, defaultEmailHTML
+		}
+		if e.subject, e.err = template.New("subject").Parse(subject); e.err != nil {
+			return
+		}
+		if e.text, e.err = template.New("text").Parse(text); e.err != nil {
+			return
+		}
+		if html != "" {
+			e.html, e.err = htmltemplate.New("html").Parse(html)
+		}
+	})
+	return e.err
+}
+
+// render renders the subject and the body (a multipart/alternative body if
+// there is an HTML template) of the alert.
+func (e *Email) render(msg *Message) (string, *emailBody, error) {
+	data := &EmailData{Alert: msg.Alert, Class: msg.Class}
+	for i := range msg.Alert.Info {
+		info := &msg.Alert.Info[i]
+		lang := strings.ToLower(info.Language)
+		if strings.HasPrefix(lang, "en") && data.English == nil {
+			data.English = info
+		} else if strings.HasPrefix(lang, "fr") && data.French == nil {
+			data.French = info
+		}
+	}
+	if data.English != nil {
+		data.Infos = append(data.Infos, data.English)
+	}
+	if data.French != nil {
+		data.Infos = append(data.Infos, data.French)
+	}
+	if data.Infos == nil {
+		for i := range msg.Alert.Info {
+			data.Infos = append(data.Infos, &msg.Alert.Info[i])
+		}
+	}
+
+	var subject, text, html bytes.Buffer
+	if err := e.subject.Execute(&subject, data); err != nil {
+		return "", nil, err
+	}
+	if err := e.text.Execute(&text, data); err != nil {
+		return "", nil, err
+	}
+	body := &emailBody{text: text.Bytes()}
+	if e.html != nil {
+		if err := e.html.Execute(&html, data); err != nil {
+			return "", nil, err
+		}
+		body.html = html.Bytes()
+	}
+	// subjects are a single line
+	return strings.Join(strings.Fields(subject.String()), " "), body, nil
+}
+
+// emailBody is the rendered body of an email.
+type emailBody struct {
+	text []byte // Text body
+	html []byte // HTML body (nil for text-only email)
+}
+
+// message returns the MIME message of the alert.
+func (e *Email) message(msg *Message, subject string, body *emailBody) []byte {
+	var buf bytes.Buffer
+	// line breaks are removed from values, so alert content cannot inject
+	// headers
+	header := func(key, val string) {
+		buf.WriteString(key + ": " + emailHeaderValue.Replace(val) + "\r\n")
+	}
+	header("From", e.From)
+	header("To", "undisclosed-recipients:;")
+	header("Subject", mime.QEncoding.Encode("utf-8", subject))
+	header("Date", time.Now().Format(time.RFC1123Z))
+	header("Message-ID", "<"+randomID()+"@"+emailDomain(e.From)+">")
+	header("X-NAADS-Identifier", msg.Alert.Identifier)
+	header("MIME-Version", "1.0")
+
+	part := func(w *bytes.Buffer, content []byte) {
+		qp := quotedprintable.NewWriter(w)
+		qp.Write(content)
+		qp.Close()
+	}
+	if body.html == nil {
+		header("Content-Type", "text/plain; charset=utf-8")
+		header("Content-Transfer-Encoding", "quoted-printable")
+		buf.WriteString("\r\n")
+		part(&buf, body.text)
+		return buf.Bytes()
+	}
+
+	var parts bytes.Buffer
+	mw := multipart.NewWriter(&parts)
+	for _, p := range []struct {
+		contentType string
+		content     []byte
+	}{{"text/plain; charset=utf-8", body.text}, {"text/html; charset=utf-8", body.html}} {
+		w, _ := mw.CreatePart(textproto.MIMEHeader{
+			"Content-Type":              {p.contentType},
+			"Content-Transfer-Encoding": {"quoted-printable"},
+		})
+		var encoded bytes.Buffer
+		part(&encoded, p.content)
+		w.Write(encoded.Bytes())
+	}
+	mw.Close()
+	header("Content-Type", "multipart/alternative; boundary="+mw.Boundary())
+	buf.WriteString("\r\n")
+	buf.Write(parts.Bytes())
+	return buf.Bytes()
+}
+
+// send delivers the message to the recipients.
+func (e *Email) send(ctx context.Context, to []string, message []byte) error {
+	host, _, err := net.SplitHostPort(e.Host)
+	if err != nil {
+		return Permanent(err)
+	}
+	conn, err := (&net.Dialer{}).DialContext(ctx, "tcp", e.Host)
+	if err != nil {
+		return err
+	}
+	if deadline, ok := ctx.Deadline(); ok {
+		conn.SetDeadline(deadline)
+	}
+	client, err := smtp.NewClient(conn, host)
+	if err != nil {
+		conn.Close()
+		return err
+	}
+	defer client.Close()
+
+	if ok, _ := client.Extension("STARTTLS"); ok {
+		config := e.TLSConfig
+		if config == nil {
+			config = &tls.Config{ServerName: host}
+		}
+		if err := client.StartTLS(config); err != nil {
+			return err
+		}
+	} else if e.RequireTLS {
+		return errors.New("Error: SMTP server does not support STARTTLS")
+	}
+	if e.Username != "" {
+		if err := client.Auth(smtp.PlainAuth("", e.Username, e.Password, host)); err != nil {
+			return err
+		}
+	}
+	if err := client.Mail(e.From); err != nil {
+		return err
+	}
+	for _, addr := range to {
+		if err := client.Rcpt(addr); err != nil {
+			return err
+		}
+	}
+	w, err := client.Data()
+	if err != nil {
+		return err
+	}
+	if _, err := w.Write(message); err != nil {
+		return err
+	}
+	if err := w.Close(); err != nil {
+		return err
+	}
+	return client.Quit()
+}
+
+// record retains the delivery result.
+func (e *Email) record(result EmailResult) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	e.results = append(e.results, result)
+	if len(e.results) > emailResultsSize {
+		e.results = e.results[len(e.results)-emailResultsSize:]
+	}
+}
+
+// done returns true if the group was already emailed the alert.
+func (e *Email) done(msg *Message, group int) bool {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	return e.delivered[msg.Alert.Identifier][group]
+}
+
+// markDone records that the group was emailed the alert, so retries skip it.
+func (e *Email) markDone(msg *Message, group int) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	if e.delivered == nil || len(e.delivered) > emailResultsSize {
+		// alerts that were never fully delivered are forgotten
+		e.delivered = make(map[string]map[int]bool)
+	}
+	if e.delivered[msg.Alert.Identifier] == nil {
+		e.delivered[msg.Alert.Identifier] = make(map[int]bool)
+	}
+	e.delivered[msg.Alert.Identifier][group] = true
+}
+
+// emailDomain returns the domain of the address.
+func emailDomain(addr string) string {
+	if i := strings.LastIndexByte(addr, '@'); i >= 0 {
+		return strings.TrimRight(addr[i+1:], ">")
+	}
+	return "localhost"
+}
+
+// randomID returns a random hex identifier.
+func randomID() string {
+	buf := make([]byte, 16)
+	rand.Read(buf)
+	return hex.EncodeToString(buf)
+}
@@ -0,0 +1,200 @@
+// Copyright (c) 2019 Tanner Ryan. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package naads
+
+import (
+	"context"
+	"io/ioutil"
+	"mime"
+	"mime/multipart"
+	"net"
+	"net/mail"
+	"net/textproto"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/thetannerryan/cap"
+)
+
+// smtpTransaction is a mail transaction received by the fake SMTP server.
+type smtpTransaction struct {
+	from string   // Reverse path (MAIL FROM)
+	to   []string // Forward paths (RCPT TO)
+	data string   // Message content (DATA)
+}
+
+// fakeSMTP starts an SMTP server accepting every transaction, sent to the
+// returned channel once complete. The server stops when the listener is
+// closed.
+func fakeSMTP(t *testing.T) (net.Listener, chan *smtpTransaction) {
+	t.Helper()
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	ch := make(chan *smtpTransaction, 4)
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			go serveSMTP(textproto.NewConn(conn), ch)
+		}
+	}()
+	return listener, ch
+}
+
+// serveSMTP handles the commands of a connection to the fake SMTP server.
+func serveSMTP(conn *textproto.Conn, ch chan *smtpTransaction) {
+	defer conn.Close()
+	conn.PrintfLine("220 localhost ESMTP")
+	tx := &smtpTransaction{}
+	for {
+		line, err := conn.ReadLine()
+		if err != nil {
+			return
+		}
+		verb := strings.ToUpper(strings.SplitN(line, " ", 2)[0])
+		switch {
+		case verb == "EHLO" || verb == "HELO":
+			conn.PrintfLine("250 localhost")
+		case strings.HasPrefix(strings.ToUpper(line), "MAIL FROM:"):
+			tx.from = strings.Trim(line[len("MAIL FROM:"):], "<> ")
+			conn.PrintfLine("250 OK")
+		case strings.HasPrefix(strings.ToUpper(line), "RCPT TO:"):
+			tx.to = append(tx.to, strings.Trim(line[len("RCPT TO:"):], "<> "))
+			conn.PrintfLine("250 OK")
+		case verb == "DATA":
+			conn.PrintfLine("354 End data with <CR><LF>.<CR><LF>")
+			data, err := ioutil.ReadAll(conn.DotReader())
+			if err != nil {
+				return
+			}
+			tx.data = string(data)
+			ch <- tx
+			tx = &smtpTransaction{}
+			conn.PrintfLine("250 OK")
+		case verb == "QUIT":
+			conn.PrintfLine("221 Bye")
+			return
+		default:
+			conn.PrintfLine("250 OK")
+		}
+	}
+}
+
+func TestEmailDeliver(t *testing.T) {
+	listener, ch := fakeSMTP(t)
+	defer listener.Close()
+	e := &Email{
+		Host: listener.Addr().String(),
+		From: "naads@example.com",
+		Groups: []*EmailGroup{
+			{Name: "all", To: []string{"a@example.com", "b@example.com"}},
+			{Name: "none", To: []string{"c@example.com"}, Filter: FilterFunc(func(*cap.Alert) bool { return false })},
+		},
+	}
+	msg := &Message{
+		Alert: &cap.Alert{
+			// line breaks in alert content must not inject headers
+			Identifier: "urn:oid:2.49.0.1.124.1\r\nBcc: injected@example.com",
+			Info: []cap.Info{
+				{Language: "en-CA", Headline: "wind warning", Description: "Strong winds.", Area: []cap.Area{{AreaDesc: "Ottawa"}}},
+				{Language: "fr-CA", Headline: "avertissement de vents", Description: "Vents forts."},
+			},
+		},
+		Class: ClassAlert,
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	if err := e.Deliver(ctx, msg); err != nil {
+		t.Fatal(err)
+	}
+
+	var tx *smtpTransaction
+	select {
+	case tx = <-ch:
+	case <-ctx.Done():
+		t.Fatal("no message received")
+	}
+	select {
+	case extra := <-ch:
+		t.Fatalf("unexpected message to %v", extra.to)
+	default:
+	}
+
+	// envelope
+	if tx.from != "naads@example.com" {
+		t.Errorf("MAIL FROM %q, want naads@example.com", tx.from)
+	}
+	if strings.Join(tx.to, ",") != "a@example.com,b@example.com" {
+		t.Errorf("RCPT TO %v, want a@example.com and b@example.com", tx.to)
+	}
+
+	// headers
+	m, err := mail.ReadMessage(strings.NewReader(tx.data))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if id := m.Header.Get("X-NAADS-Identifier"); id != "urn:oid:2.49.0.1.124.1Bcc: injected@example.com" {
+		t.Errorf("X-NAADS-Identifier %q", id)
+	}
+	if bcc := m.Header.Get("Bcc"); bcc != "" {
+		t.Errorf("injected Bcc header %q", bcc)
+	}
+	subject, err := new(mime.WordDecoder).DecodeHeader(m.Header.Get("Subject"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if subject != "wind warning / avertissement de vents" {
+		t.Errorf("Subject %q", subject)
+	}
+	if to := m.Header.Get("To"); to != "undisclosed-recipients:;" {
+		t.Errorf("To %q", to)
+	}
+
+	// multipart body
+	mediaType, params, err := mime.ParseMediaType(m.Header.Get("Content-Type"))
+	if err != nil || mediaType != "multipart/alternative" {
+		t.Fatalf("Content-Type %q (%v)", m.Header.Get("Content-Type"), err)
+	}
+	mr := multipart.NewReader(m.Body, params["boundary"])
+	want := []struct {
+		contentType string
+		contains    []string
+	}{
+		{"text/plain; charset=utf-8", []string{"wind warning", "Strong winds.", "- Ottawa", "avertissement de vents"}},
+		{"text/html; charset=utf-8", []string{`<div lang="en-CA">`, "<h2>wind warning</h2>", "<li>Ottawa</li>", "<h2>avertissement de vents</h2>"}},
+	}
+	for _, w := range want {
+		// quoted-printable parts are decoded by the reader
+		p, err := mr.NextPart()
+		if err != nil {
+			t.Fatalf("%s part: %s", w.contentType, err)
+		}
+		if ct := p.Header.Get("Content-Type"); ct != w.contentType {
+			t.Errorf("part Content-Type %q, want %q", ct, w.contentType)
+		}
+		content, err := ioutil.ReadAll(p)
+		if err != nil {
+			t.Fatal(err)
+		}
+		for _, s := range w.contains {
+			if !strings.Contains(string(content), s) {
+				t.Errorf("%s part does not contain %q:\n%s", w.contentType, s, content)
+			}
+		}
+	}
+	if _, err := mr.NextPart(); err == nil {
+		t.Error("unexpected third part")
+	}
+
+	results := e.Results()
+	if len(results) != 1 || results[0].Group != "all" || results[0].Recipients != 2 || results[0].Err != nil {
+		t.Errorf("unexpected results %+v", results)
+	}
+}
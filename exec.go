@@ -0,0 +1,181 @@
+// Copyright (c) 2019 Tanner Ryan. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package naads
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	execTempFail  = 75   // default exit code requesting a retry (EX_TEMPFAIL)
+	execMaxStderr = 1024 // maximum length of the standard error in errors
+)
+
+// Exec is a sink running a command for each message. The JSON encoding of the
+// alert (cap package JSON tags) or its original XML is written to the
+// standard input of the command, and the key fields of the alert are passed
+// in environment variables:
+//
+//	NAADS_IDENTIFIER  identifier of the alert
+//	NAADS_SENDER      sender of the alert
+//	NAADS_SENT        sent time of the alert (RFC 3339)
+//	NAADS_STATUS      status of the alert (e.g. Actual)
+//	NAADS_MSGTYPE     message type of the alert (e.g. Alert, Update)
+//	NAADS_REFERENCES  referenced identifiers (space separated)
+//	NAADS_CLASS       class of the message (e.g. ALERT)
+//	NAADS_FEED        name of the feed the message was received from
+//	NAADS_EVENT       event of the primary info block
+//	NAADS_HEADLINE    headline of the primary info block
+//	NAADS_SEVERITY    most severe severity of the info blocks
+//	NAADS_URGENCY     urgency of the primary info block
+//	NAADS_EXPIRES     expiry time of the alert (RFC 3339)
+//	NAADS_AREAS       area descriptions of the primary info block ("; " separated)
+//	NAADS_PROVINCES   province abbreviations of the areas (space separated)
+//
+// The command inherits the environment of the client (including any secrets
+// it holds) unless CleanEnv is set, in which case it only receives the
+// variables above and Env.
+//
+// The command is killed when the delivery times out. An exit code listed in
+// RetryCodes (or a timeout) is retried by the output; any other failure is
+// permanent. The number of commands running at once is limited by the
+// Workers of the output.
+type Exec struct {
+	Command    string        // Path of the command (REQUIRED)
+	Args       []string      // Arguments of the command
+	Dir        string        // Working directory of the command (defaults to the current directory)
+	Env        []string      // Additional environment variables ("KEY=value")
+	CleanEnv   bool          // Indicator to not pass the environment of the client to the command
+	Format     WebhookFormat // Format of the standard input
+	RetryCodes []int         // Exit codes retried by the output (defaults to 75, EX_TEMPFAIL)
+}
+
+// Deliver runs the command for the message.
+func (e *Exec) Deliver(ctx context.Context, msg *Message) error {
+	var stdin []byte
+	if e.Format == WebhookXML {
+		stdin = msg.Raw
+	} else {
+		var err error
+		if stdin, err = json.Marshal(msg.Alert); err != nil {
+			return Permanent(err)
+		}
+	}
+
+	cmd := exec.CommandContext(ctx, e.Command, e.Args...)
+	cmd.Dir = e.Dir
+	var env []string
+	if !e.CleanEnv {
+		env = os.Environ()
+	}
+	cmd.Env = append(append(env, execEnv(msg)...), e.Env...)
+	cmd.Stdin = bytes.NewReader(stdin)
+	// a file (rather than a pipe) is used, so a timed out command returns
+	// even if processes it started still hold the standard error open
+	stderr, err := ioutil.TempFile("", "naads-exec-")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(stderr.Name())
+	defer stderr.Close()
+	cmd.Stderr = stderr
+
+	err = cmd.Run()
+	if err == nil {
+		return nil
+	}
+	if ctx.Err() != nil {
+		return errors.New("Error: command timed out")
+	}
+	exitErr, ok := err.(*exec.ExitError)
+	if !ok {
+		// command could not be started
+		return Permanent(err)
+	}
+	code := exitErr.ExitCode()
+	err = errors.New("Error: command exited with code " + strconv.Itoa(code) + execStderr(stderr))
+	if e.retry(code) {
+		return err
+	}
+	return Permanent(err)
+}
+
+// retry returns whether the exit code is retried.
+func (e *Exec) retry(code int) bool {
+	if len(e.RetryCodes) == 0 {
+		return code == execTempFail
+	}
+	for _, c := range e.RetryCodes {
+		if c == code {
+			return true
+		}
+	}
+	return false
+}
+
+// execEnv returns the environment variables describing the message.
+func execEnv(msg *Message) []string {
+	alert := msg.Alert
+	env := []string{
+		"NAADS_IDENTIFIER=" + alert.Identifier,
+		"NAADS_SENDER=" + alert.Sender,
+		"NAADS_SENT=" + execTime(alert.Sent.Time()),
+		"NAADS_STATUS=" + alert.Status.String(),
+		"NAADS_MSGTYPE=" + alert.MsgType.String(),
+		"NAADS_REFERENCES=" + strings.Join(referencedIdentifiers(alert), " "),
+		"NAADS_CLASS=" + msg.Class.String(),
+		"NAADS_FEED=" + msg.Feed,
+	}
+	if len(alert.Info) == 0 {
+		return env
+	}
+	info := primaryInfo(alert)
+	var areas []string
+	for _, area := range info.Area {
+		areas = append(areas, area.AreaDesc)
+	}
+	return append(env,
+		"NAADS_EVENT="+info.Event,
+		"NAADS_HEADLINE="+info.Headline,
+		"NAADS_SEVERITY="+maxSeverity(alert).String(),
+		"NAADS_URGENCY="+info.Urgency.String(),
+		"NAADS_EXPIRES="+execTime(expires(alert)),
+		"NAADS_AREAS="+strings.Join(areas, "; "),
+		"NAADS_PROVINCES="+strings.Join(alertProvinces(alert), " "),
+	)
+}
+
+// execTime returns the time in RFC 3339 format, or an empty string if it is
+// not set.
+func execTime(t time.Time) string {
+	if t.IsZero() {
+		return ""
+	}
+	return t.Format(time.RFC3339)
+}
+
+// execStderr returns the standard error of the command for inclusion in an
+// error message.
+func execStderr(file *os.File) string {
+	data, _ := ioutil.ReadAll(io.NewSectionReader(file, 0, execMaxStderr+1))
+	stderr := strings.TrimSpace(string(data))
+	if stderr == "" {
+		return ""
+	}
+	if len(stderr) > execMaxStderr {
+		stderr = truncateUTF8(stderr, execMaxStderr) + "..."
+	}
+	return ": " + stderr
+}
@@ -18,8 +18,15 @@ import (
 var (
 	startSignature = []byte("<alert")   // byte sequence signifying start of alert
 	endSignature   = []byte("</alert>") // byte sequence signifying end of alert
+	xmlDeclaration = []byte("<?xml")    // byte sequence signifying start of the XML declaration
+	dtdSignatures  = [][]byte{          // byte sequences signifying document type declarations
+		[]byte("<!DOCTYPE"),
+		[]byte("<!ENTITY"),
+	}
 )
 
+const defaultMaxMessageSize = 5 * 1024 * 1024 // maximum message size of the NAADS protocol (5MB)
+
 // Feed is a TCP client for the NAADS system. It will be used for receiving the
 // TCP data stream and for converting the raw XML to CAP Alert structs.
 type Feed struct {
@@ -31,25 +38,38 @@ type Feed struct {
 	ReconnectDelay  time.Duration   // Delay before attempting reconnection
 	LogStatus       bool            // Indicator to log feed status (incoming messages + disconnections) to stdout
 	LogHeartbeat    bool            // If LogStatus is enabled, indicator to log heartbeats to stdout
-	ch              chan *cap.Alert // Alert output channel
+	MaxMessageSize  int             // Maximum size of a message in bytes (defaults to 5MB, the protocol maximum)
+	QuarantineDir   string          // Directory where rejected messages are saved for inspection (empty disables quarantine)
+	QuarantineMax   int             // Maximum number of messages kept in QuarantineDir, oldest removed first (defaults to 1000)
+	ch              chan *Message   // Message output channel
+	rules           []ClassRule     // Classification rules (provided by the client)
 	isConnected     bool            // Indicator if connection is currently established
 	lastMsgTime     time.Time       // Last time a message (alert or heartbeat) was received (not currently used)
 	lastMsg         string          // Type and ID of last message that was received
 	countDisconnect int             // Count of feed disconnections
-	countAlert      int             // Count of alert messages
-	countHeartbeat  int             // Count of heartbeat messages
-	countTest       int             // Count of test messages
-	countUnknown    int             // Count of unknown messages
+	counts          [classCount]int // Count of messages per class
+}
+
+// Message is a NAADS message received by the client, along with the metadata
+// gathered while it was processed.
+type Message struct {
+	Alert     *cap.Alert    // Parsed alert
+	Raw       []byte        // Original XML document (including the XML declaration)
+	Class     Class         // Classification of the alert
+	Feed      string        // Name of the feed the message was received from
+	Received  time.Time     // Time the message was received
+	Signature *Verification // Result of signature verification (nil if not verified)
+	Resources []*Resource   // Decoded and fetched resources of the alert
 }
 
 // start will establish a connection with the NAADS server (via internal
-// connect) and return an Alert output channel. The feed will automatically
+// connect) and return a message output channel. The feed will automatically
 // perform health checks and perform reconnects as necessary.
-func (feed *Feed) start() chan *cap.Alert {
+func (feed *Feed) start() chan *Message {
 	// log.Printf to stdout
 	log.SetOutput(os.Stdout)
-	// create an output channel for alerts
-	feed.ch = make(chan *cap.Alert, 16)
+	// create an output channel for messages
+	feed.ch = make(chan *Message, 16)
 	feed.connect()
 	return feed.ch
 }
@@ -77,9 +97,9 @@ func (feed *Feed) connect() {
 			return
 		}
 
-		// Temp buffer for chunks (protocol uses max of 5MB); data buffer for
-		// storing entire message.
-		temp := make([]byte, 6*1024*1024)
+		// Temp buffer for chunks; data buffer for storing the partially
+		// received message (bounded by MaxMessageSize).
+		temp := make([]byte, 64*1024)
 		data := make([]byte, 0)
 
 		// if block is reached, feed was successfully connected
@@ -111,61 +131,112 @@ func (feed *Feed) connect() {
 				return
 			}
 
-			// if start signature encountered, clear data buffer for new data
-			startIndex := bytes.Index(temp, startSignature)
-			if startIndex != -1 {
-				// clear data buffer
-				data = data[:0]
-			}
-
-			// append last chunk of temp buffer to data buffer
-			lastChunk := temp[:n]
-			data = append(data, lastChunk...)
+			// append chunk to data buffer, handling every complete message
+			data = f.frame(append(data, temp[:n]...))
+		}
+	}(feed)
+}
 
-			// if end signature encountered, the data is ready to be parsed
-			endIndex := bytes.Index(lastChunk, endSignature)
-			if endIndex != -1 {
-				f.handleMessage(data)
+// frame extracts and handles every complete message of the data buffer,
+// returning the remaining partial data. A message runs from the XML
+// declaration preceding the start signature (or the start signature, if there
+// is none) to the end signature. Bytes outside of messages are discarded, and
+// a partial message exceeding MaxMessageSize is quarantined and dropped.
+func (feed *Feed) frame(data []byte) []byte {
+	max := feed.MaxMessageSize
+	if max <= 0 {
+		max = defaultMaxMessageSize
+	}
+	for {
+		start := bytes.Index(data, startSignature)
+		if start == -1 {
+			if decl := bytes.LastIndex(data, xmlDeclaration); decl != -1 && len(data)-decl <= max {
+				// retain the XML declaration of the next message
+				return append(data[:0], data[decl:]...)
+			}
+			// retain a possible partial start signature or XML declaration
+			if keep := len(startSignature) - 1; len(data) > keep {
+				data = append(data[:0], data[len(data)-keep:]...)
 			}
+			return data
 		}
-	}(feed)
+		// previous messages were removed from the buffer, so a preceding XML
+		// declaration belongs to this message
+		if decl := bytes.LastIndex(data[:start], xmlDeclaration); decl != -1 {
+			start = decl
+		}
+		end := bytes.Index(data[start:], endSignature)
+		if end == -1 {
+			data = append(data[:0], data[start:]...)
+			if len(data) > max {
+				feed.reject(data, fmt.Errorf("Error: message exceeds maximum size of %d bytes", max))
+				return data[:0]
+			}
+			return data
+		}
+		end += start + len(endSignature)
+		if end-start > max {
+			feed.reject(data[start:end], fmt.Errorf("Error: message exceeds maximum size of %d bytes", max))
+		} else {
+			feed.handleMessage(data[start:end])
+		}
+		data = append(data[:0], data[end:]...)
+	}
+}
+
+// reject counts, logs and quarantines a message that could not be accepted.
+func (feed *Feed) reject(data []byte, err error) {
+	feed.counts[ClassUnknown]++
+	feed.lastMsg = ClassUnknown.String()
+	feed.lastMsgTime = time.Now()
+	if feed.LogStatus {
+		log.Printf("%s [ERROR]  REJECTED MESSAGE (%s)\n", feed.Name, err)
+	}
+	if feed.QuarantineDir != "" {
+		if qerr := quarantine(feed.QuarantineDir, feed.QuarantineMax, feed.Name, data, err); qerr != nil && feed.LogStatus {
+			log.Printf("%s [ERROR]  Unable to quarantine message: %s\n", feed.Name, qerr)
+		}
+	}
 }
 
 // handleMessage will convert the XML byte data into an Alert struct using the
-// cap package. It will pass this struct through the Feed output channel.
+// cap package. The alert is classified and passed through the Feed output
+// channel; the client is responsible for routing it by class.
 func (feed *Feed) handleMessage(data []byte) {
+	// document type declarations (only permitted in the prolog) are never
+	// used by NAADS, and are rejected to prevent entity expansion payloads
+	prolog := data[:bytes.Index(data, startSignature)]
+	for _, sig := range dtdSignatures {
+		if bytes.Contains(prolog, sig) {
+			feed.reject(data, fmt.Errorf("Error: document type declarations are not permitted"))
+			return
+		}
+	}
+
 	alert, err := cap.ParseCAP(data)
 	if err != nil {
-		// TODO: better handling of malformed messages
-		if feed.LogStatus {
-			log.Printf("%s [ERROR]  MALFORMED MESSAGE\n", feed.Name)
-			fmt.Printf("%s\n", err)
-			fmt.Printf("%s\n\n", string(data))
-		}
-		feed.countUnknown++
-	} else {
-		// identify message, updating the corresponding count
-		if alert.Status == cap.StatusSystem && alert.Sender == "NAADS-Heartbeat" {
-			feed.lastMsg = "HEARTBEAT " + alert.Identifier
-			feed.countHeartbeat++
-		} else if alert.Status == cap.StatusTest {
-			feed.lastMsg = "TEST " + alert.Identifier
-			feed.countTest++
-		} else {
-			feed.lastMsg = "ALERT " + alert.Identifier
-			feed.countAlert++
-		}
-		feed.lastMsgTime = time.Now()
+		feed.reject(data, err)
+		return
+	}
 
-		if feed.LogStatus {
-			if feed.LogHeartbeat || (!feed.LogHeartbeat && alert.Sender != "NAADS-Heartbeat") {
-				log.Printf("%s [STATUS] INCOMING %s\n", feed.Name, feed.lastMsg)
-			}
-		}
+	// identify message, updating the corresponding count
+	class := Classify(alert, feed.rules)
+	feed.lastMsg = class.String() + " " + alert.Identifier
+	feed.counts[class]++
+	feed.lastMsgTime = time.Now()
 
-		// broadcast message on channel
-		if feed.SendHeartbeat || (!feed.SendHeartbeat && alert.Sender != "NAADS-Heartbeat") {
-			feed.ch <- alert
+	if feed.LogStatus {
+		if feed.LogHeartbeat || class != ClassHeartbeat {
+			log.Printf("%s [STATUS] INCOMING %s\n", feed.Name, feed.lastMsg)
 		}
 	}
+
+	// broadcast message on channel (the data buffer is reused by connect)
+	feed.ch <- &Message{
+		Alert:    alert,
+		Raw:      append([]byte(nil), data...),
+		Class:    class,
+		Feed:     feed.Name,
+		Received: feed.lastMsgTime,
+	}
 }
@@ -0,0 +1,292 @@
+// Copyright (c) 2019 Tanner Ryan. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package naads
+
+import (
+	"errors"
+	"io"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/thetannerryan/cap"
+)
+
+const (
+	defaultFetchTimeout   = 30 * time.Second // default timeout of resource downloads
+	defaultFetchMaxSize   = 10 << 20         // default maximum size of downloaded resources (10MB)
+	defaultFetchWorkers   = 4                // default number of concurrent downloads
+	defaultFetchQueueSize = 64               // default number of messages waiting for downloads
+	defaultFetchWait      = time.Minute      // default time a message waits for its downloads
+)
+
+// ResourceFetcher downloads resources referenced by URI into a local
+// content-addressed cache. Files in the cache are named by the SHA-1 digest
+// of their content, so a resource is downloaded once regardless of the number
+// of alerts referencing it.
+//
+// When used by the client, downloads run on their own workers rather than on
+// the delivery path. The alert is passed to the output channel and
+// subscribers on receipt, without waiting for its resources; the message,
+// whose Resources hold the local paths of the downloaded content, is retained
+// for Lookup and delivered to the outputs once its downloads complete, or
+// once it has waited for them for Wait. Messages are retained and delivered to
+// the outputs in order of arrival, so a message without remote resources
+// waits for the messages received before it (an Update or Cancel never
+// reaches an output before the alert it references). Messages arriving while
+// QueueSize messages are waiting for downloads are delivered without their
+// remote resources.
+type ResourceFetcher struct {
+	CacheDir  string        // Directory of the cache (REQUIRED)
+	Timeout   time.Duration // Timeout of each download (defaults to 30 seconds)
+	Wait      time.Duration // Maximum time a message waits for its downloads before it is delivered (defaults to 1 minute)
+	MaxSize   int64         // Maximum size of a resource in bytes (defaults to 10MB)
+	Workers   int           // Number of messages whose resources are downloaded at once (defaults to 4)
+	QueueSize int           // Number of messages waiting for downloads (defaults to 64)
+
+	mu     sync.Mutex        // Mutex protecting paths
+	paths  map[string]string // Cached paths keyed by URI
+	client *http.Client      // HTTP client performing downloads
+}
+
+// fetchJob is a message waiting for its resources referenced by URI to be
+// downloaded, and for the messages received before it to be completed.
+type fetchJob struct {
+	msg       *Message      // Message referencing the resources
+	deliver   bool          // Indicator to deliver the message to the outputs
+	resources []*Resource   // Copies of the remote resources of the message, updated by the download worker
+	fetched   chan struct{} // Closed once the resources are downloaded
+	completed chan struct{} // Closed once the message is completed
+}
+
+// startFetching launches the download workers of the fetcher.
+func (c *Client) startFetching() {
+	workers := c.Fetcher.Workers
+	if workers <= 0 {
+		workers = defaultFetchWorkers
+	}
+	size := c.Fetcher.QueueSize
+	if size <= 0 {
+		size = defaultFetchQueueSize
+	}
+	c.fetches = make(chan *fetchJob, size)
+	for i := 0; i < workers; i++ {
+		go func() {
+			for job := range c.fetches {
+				c.fetchResources(job.msg.Alert, job.resources)
+				close(job.fetched)
+			}
+		}()
+	}
+}
+
+// completeInOrder queues the downloads of the remote resources of the message,
+// and completes the message once they are downloaded (or it has waited for
+// them for the fetcher's Wait) and the messages received before it are
+// completed.
+func (c *Client) completeInOrder(msg *Message, deliver bool) {
+	job := &fetchJob{msg: msg, deliver: deliver, fetched: make(chan struct{}), completed: make(chan struct{})}
+	for _, r := range msg.Resources {
+		if r.Remote {
+			fetched := *r
+			job.resources = append(job.resources, &fetched)
+		}
+	}
+	if len(job.resources) == 0 {
+		close(job.fetched)
+	} else {
+		select {
+		case c.fetches <- job:
+		default:
+			if c.LogControl {
+				log.Printf("CONTROL [ERROR]  Resource fetch queue is full; not fetching resources of %s\n", msg.Alert.Identifier)
+			}
+			for _, r := range job.resources {
+				r.Err = errors.New("Error: resource fetch queue is full")
+			}
+			close(job.fetched)
+		}
+	}
+
+	c.mu.Lock()
+	prev := c.lastJob
+	c.lastJob = job
+	c.mu.Unlock()
+
+	// complete at once if nothing is outstanding
+	if len(job.resources) == 0 && (prev == nil || isClosed(prev.completed)) {
+		c.complete(msg, deliver)
+		close(job.completed)
+		return
+	}
+	go c.await(job, prev)
+}
+
+// await completes the message of the job once its resources are downloaded
+// (or the wait expires) and the previous job is completed.
+func (c *Client) await(job *fetchJob, prev *fetchJob) {
+	wait := c.Fetcher.Wait
+	if wait <= 0 {
+		wait = defaultFetchWait
+	}
+	timer := time.NewTimer(wait)
+	select {
+	case <-job.fetched:
+		timer.Stop()
+		// the download worker is done with the copies
+		i := 0
+		for j, r := range job.msg.Resources {
+			if r.Remote {
+				job.msg.Resources[j] = job.resources[i]
+				i++
+			}
+		}
+	case <-timer.C:
+		// the download worker may still update the copies, which are
+		// discarded
+		for _, r := range job.msg.Resources {
+			if r.Remote {
+				r.Err = errors.New("Error: resource was not downloaded within " + wait.String())
+			}
+		}
+	}
+	c.logResources(job.msg, true)
+	if prev != nil {
+		<-prev.completed
+	}
+	c.complete(job.msg, job.deliver)
+	close(job.completed)
+}
+
+// isClosed returns true if the channel is closed.
+func isClosed(ch chan struct{}) bool {
+	select {
+	case <-ch:
+		return true
+	default:
+		return false
+	}
+}
+
+// Fetch returns the path of the cached content of the CAP resource's URI,
+// downloading it if it is not cached. Downloaded content is verified against
+// the declared size and SHA-1 digest of the resource.
+func (f *ResourceFetcher) Fetch(res *cap.Resource) (string, error) {
+	u, err := url.Parse(strings.TrimSpace(res.URI))
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return "", errors.New("Error: resource URI " + strconv.Quote(res.URI) + " is not an HTTP URL")
+	}
+	uri := u.String()
+
+	// cached by URI
+	f.mu.Lock()
+	path, ok := f.paths[uri]
+	f.mu.Unlock()
+	if ok {
+		if _, err := os.Stat(path); err == nil {
+			return path, nil
+		}
+	}
+	// cached by declared digest
+	if digest := strings.ToLower(strings.TrimSpace(res.Digest)); digest != "" && !strings.ContainsAny(digest, `/\.*?[`) {
+		if matches, _ := filepath.Glob(filepath.Join(f.CacheDir, digest+".*")); len(matches) > 0 {
+			f.remember(uri, matches[0])
+			return matches[0], nil
+		}
+	}
+
+	data, err := f.download(uri)
+	if err != nil {
+		return "", err
+	}
+	if err := VerifyResource(res, data); err != nil {
+		return "", err
+	}
+	path, err = SaveResource(data, res.MimeType, f.CacheDir)
+	if err != nil {
+		return "", err
+	}
+	f.remember(uri, path)
+	return path, nil
+}
+
+// download retrieves the content of the URI, enforcing the timeout and
+// maximum size.
+func (f *ResourceFetcher) download(uri string) ([]byte, error) {
+	timeout := f.Timeout
+	if timeout <= 0 {
+		timeout = defaultFetchTimeout
+	}
+	maxSize := f.MaxSize
+	if maxSize <= 0 {
+		maxSize = defaultFetchMaxSize
+	}
+	f.mu.Lock()
+	if f.client == nil {
+		f.client = &http.Client{Timeout: timeout}
+	}
+	client := f.client
+	f.mu.Unlock()
+
+	resp, err := client.Get(uri)
+	if err != nil {
+		return nil, errors.New("Error: cannot fetch resource: " + err.Error())
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, errors.New("Error: cannot fetch resource: " + resp.Status)
+	}
+	if resp.ContentLength > maxSize {
+		return nil, errors.New("Error: resource size " + strconv.FormatInt(resp.ContentLength, 10) + " exceeds maximum of " + strconv.FormatInt(maxSize, 10))
+	}
+	data, err := ioutil.ReadAll(io.LimitReader(resp.Body, maxSize+1))
+	if err != nil {
+		return nil, errors.New("Error: cannot fetch resource: " + err.Error())
+	}
+	if int64(len(data)) > maxSize {
+		return nil, errors.New("Error: resource exceeds maximum size of " + strconv.FormatInt(maxSize, 10))
+	}
+	return data, nil
+}
+
+// remember records the cached path of the URI.
+func (f *ResourceFetcher) remember(uri, path string) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if f.paths == nil {
+		f.paths = make(map[string]string)
+	}
+	f.paths[uri] = path
+}
+
+// describeFile sets the size, digest and detected MIME type of the resource
+// from its cached file.
+func describeFile(r *Resource) error {
+	file, err := os.Open(r.Path)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+	info, err := file.Stat()
+	if err != nil {
+		return err
+	}
+	head := make([]byte, 512)
+	n, err := io.ReadFull(file, head)
+	if err != nil && err != io.ErrUnexpectedEOF && err != io.EOF {
+		return err
+	}
+	r.Size = int(info.Size())
+	r.Digest = strings.TrimSuffix(filepath.Base(r.Path), filepath.Ext(r.Path))
+	r.Sniffed = SniffMIME(head[:n])
+	return nil
+}
@@ -0,0 +1,88 @@
+// Copyright (c) 2019 Tanner Ryan. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package naads
+
+import (
+	"context"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/thetannerryan/cap"
+)
+
+// captureSink passes the delivered messages to its channel.
+type captureSink chan *Message
+
+// Deliver passes the message to the channel.
+func (s captureSink) Deliver(ctx context.Context, msg *Message) error {
+	s <- msg
+	return nil
+}
+
+func TestFetchOrder(t *testing.T) {
+	// the resource of the alert is downloaded slowly
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		time.Sleep(200 * time.Millisecond)
+		w.Write([]byte("resource"))
+	}))
+	defer server.Close()
+	dir, err := ioutil.TempDir("", "naads")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	sink := make(captureSink, 2)
+	c := &Client{
+		Fetcher: &ResourceFetcher{CacheDir: dir, Wait: 5 * time.Second},
+		Outputs: []*Output{{Name: "capture", Sink: sink}},
+	}
+	c.ch = make(chan *cap.Alert, 2)
+	c.Outputs[0].start()
+	c.startFetching()
+
+	alert := &Message{
+		Alert: &cap.Alert{
+			Identifier: "alert",
+			MsgType:    cap.MsgTypeAlert,
+			Info:       []cap.Info{{Resource: []cap.Resource{{ResourceDesc: "text", MimeType: "text/plain", URI: server.URL + "/resource.txt"}}}},
+		},
+		Class: ClassAlert,
+	}
+	cancel := &Message{
+		Alert: &cap.Alert{
+			Identifier: "cancel",
+			MsgType:    cap.MsgTypeCancel,
+		},
+		Class: ClassAlert,
+	}
+	c.forward(&Feed{}, alert)
+	c.forward(&Feed{}, cancel)
+
+	// the output channel is not delayed by the download
+	for _, want := range []string{"alert", "cancel"} {
+		if got := (<-c.ch).Identifier; got != want {
+			t.Errorf("output channel received %s, want %s", got, want)
+		}
+	}
+	// the outputs receive the messages in order of arrival
+	for _, want := range []string{"alert", "cancel"} {
+		select {
+		case msg := <-sink:
+			if msg.Alert.Identifier != want {
+				t.Fatalf("output received %s, want %s", msg.Alert.Identifier, want)
+			}
+			if want == "alert" && (len(msg.Resources) != 1 || msg.Resources[0].Path == "" || msg.Resources[0].Err != nil) {
+				t.Errorf("resource not downloaded: %+v", msg.Resources)
+			}
+		case <-time.After(5 * time.Second):
+			t.Fatalf("output did not receive %s", want)
+		}
+	}
+}
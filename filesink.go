@@ -0,0 +1,232 @@
+// Copyright (c) 2019 Tanner Ryan. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package naads
+
+import (
+	"compress/gzip"
+	"context"
+	"encoding/json"
+	"io"
+	"io/ioutil"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+)
+
+// FileFormat is the format of the files written by a FileSink.
+type FileFormat int
+
+// File sink formats.
+const (
+	FileJSONLines FileFormat = iota // One JSON encoded alert per line (cap package JSON tags)
+	FileXML                         // One file per alert containing the original XML
+)
+
+const defaultFilePrefix = "naads" // default base name of JSON Lines files
+
+// FileSink is a sink writing messages to disk, either appending them as JSON
+// Lines to a file (rotated by size or age), or writing the original XML of
+// each message to a file named by its sent time and identifier.
+type FileSink struct {
+	Dir       string        // Directory of the files (REQUIRED)
+	Format    FileFormat    // Format of the files
+	Prefix    string        // Base name of the JSON Lines file (defaults to "naads")
+	MaxSize   int64         // Size in bytes at which the JSON Lines file is rotated (0 disables)
+	MaxAge    time.Duration // Age at which the JSON Lines file is rotated (0 disables)
+	Compress  bool          // Indicator to gzip rotated JSON Lines files and XML files
+	Sync      bool          // Indicator to fsync every write to stable storage
+	LogStatus bool          // Indicator to log failures to compress rotated files to stdout
+	mu        sync.Mutex    // Mutex protecting the open file
+	file      *os.File      // Current JSON Lines file
+	size      int64         // Size of the current JSON Lines file
+	opened    time.Time     // Time the current JSON Lines file was opened
+}
+
+// Deliver writes the message to disk.
+func (s *FileSink) Deliver(ctx context.Context, msg *Message) error {
+	if s.Format == FileXML {
+		return s.writeXML(msg)
+	}
+	line, err := json.Marshal(msg.Alert)
+	if err != nil {
+		return Permanent(err)
+	}
+	return s.writeLine(append(line, '\n'))
+}
+
+// Close closes the current JSON Lines file.
+func (s *FileSink) Close() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.file == nil {
+		return nil
+	}
+	err := s.file.Close()
+	s.file = nil
+	return err
+}
+
+// writeLine appends the line to the JSON Lines file, rotating the file first
+// if it has reached its maximum size or age. A failed write is truncated, so a
+// retried delivery does not duplicate the line.
+func (s *FileSink) writeLine(line []byte) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.file != nil && ((s.MaxSize > 0 && s.size+int64(len(line)) > s.MaxSize && s.size > 0) ||
+		(s.MaxAge > 0 && time.Since(s.opened) >= s.MaxAge)) {
+		if err := s.rotate(); err != nil {
+			return err
+		}
+	}
+	if s.file == nil {
+		if err := s.open(); err != nil {
+			return err
+		}
+	}
+	_, err := s.file.Write(line)
+	if err == nil && s.Sync {
+		err = s.file.Sync()
+	}
+	if err != nil {
+		if terr := s.file.Truncate(s.size); terr != nil {
+			// the file is reopened (and its size read) by the next write
+			s.file.Close()
+			s.file = nil
+		}
+		return err
+	}
+	s.size += int64(len(line))
+	return nil
+}
+
+// path returns the path of the current JSON Lines file.
+func (s *FileSink) path() string {
+	prefix := s.Prefix
+	if prefix == "" {
+		prefix = defaultFilePrefix
+	}
+	return filepath.Join(s.Dir, prefix+".jsonl")
+}
+
+// open opens (or creates) the current JSON Lines file for appending.
+func (s *FileSink) open() error {
+	if err := os.MkdirAll(s.Dir, 0750); err != nil {
+		return err
+	}
+	file, err := os.OpenFile(s.path(), os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0640)
+	if err != nil {
+		return err
+	}
+	info, err := file.Stat()
+	if err != nil {
+		file.Close()
+		return err
+	}
+	s.file = file
+	s.size = info.Size()
+	s.opened = time.Now()
+	// the age of an existing file counts from its last modification
+	if s.size > 0 {
+		s.opened = info.ModTime()
+	}
+	return nil
+}
+
+// rotate closes the current JSON Lines file and renames it with a timestamp,
+// compressing it in the background if requested.
+func (s *FileSink) rotate() error {
+	if err := s.file.Close(); err != nil {
+		return err
+	}
+	s.file = nil
+	current := s.path()
+	rotated := strings.TrimSuffix(current, ".jsonl") + "-" + time.Now().UTC().Format("20060102T150405.000Z") + ".jsonl"
+	if err := os.Rename(current, rotated); err != nil {
+		return err
+	}
+	if s.Compress {
+		go func() {
+			if err := compressFile(rotated, s.Sync); err != nil && s.LogStatus {
+				log.Printf("FILE [ERROR]  Unable to compress %s: %s\n", rotated, err)
+			}
+		}()
+	}
+	return nil
+}
+
+// writeXML writes the original XML of the message to its own file. The file
+// is written to a temporary name and renamed, so partial files are never
+// visible.
+func (s *FileSink) writeXML(msg *Message) error {
+	if err := os.MkdirAll(s.Dir, 0750); err != nil {
+		return err
+	}
+	name := msg.Alert.Sent.Time().UTC().Format("20060102T150405Z") + "-" + safeFilename(msg.Alert.Identifier) + ".xml"
+	if s.Compress {
+		name += ".gz"
+	}
+
+	tmp, err := ioutil.TempFile(s.Dir, ".naads-")
+	if err != nil {
+		return err
+	}
+	var w io.Writer = tmp
+	var zw *gzip.Writer
+	if s.Compress {
+		zw = gzip.NewWriter(tmp)
+		w = zw
+	}
+	if _, err = w.Write(msg.Raw); err == nil && zw != nil {
+		err = zw.Close()
+	}
+	if err == nil && s.Sync {
+		err = tmp.Sync()
+	}
+	if cerr := tmp.Close(); err == nil {
+		err = cerr
+	}
+	if err == nil {
+		err = os.Chmod(tmp.Name(), 0640)
+	}
+	if err == nil {
+		err = os.Rename(tmp.Name(), filepath.Join(s.Dir, name))
+	}
+	if err != nil {
+		os.Remove(tmp.Name())
+	}
+	return err
+}
+
+// compressFile replaces the file with a gzip compressed copy (name.gz).
+func compressFile(path string, sync bool) error {
+	in, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+	out, err := os.OpenFile(path+".gz", os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0640)
+	if err != nil {
+		return err
+	}
+	zw := gzip.NewWriter(out)
+	if _, err = io.Copy(zw, in); err == nil {
+		err = zw.Close()
+	}
+	if err == nil && sync {
+		err = out.Sync()
+	}
+	if cerr := out.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		os.Remove(path + ".gz")
+		return err
+	}
+	return os.Remove(path)
+}
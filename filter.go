@@ -0,0 +1,144 @@
+// Copyright (c) 2019 Tanner Ryan. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package naads
+
+import (
+	"log"
+
+	"github.com/thetannerryan/cap"
+)
+
+// Filter decides if an alert is relevant to a subscriber.
+type Filter interface {
+	Match(alert *cap.Alert) bool // Match returns true if the alert should be delivered
+}
+
+// FilterFunc is an adapter to allow the use of ordinary functions as filters.
+type FilterFunc func(alert *cap.Alert) bool
+
+// Match calls f(alert).
+func (f FilterFunc) Match(alert *cap.Alert) bool {
+	return f(alert)
+}
+
+// AnyFilter matches alerts matched by at least one of its filters.
+type AnyFilter []Filter
+
+// Match returns true if any of the filters match the alert.
+func (filters AnyFilter) Match(alert *cap.Alert) bool {
+	for _, f := range filters {
+		if f.Match(alert) {
+			return true
+		}
+	}
+	return false
+}
+
+// AllFilter matches alerts matched by every one of its filters.
+type AllFilter []Filter
+
+// Match returns true if all of the filters match the alert.
+func (filters AllFilter) Match(alert *cap.Alert) bool {
+	for _, f := range filters {
+		if !f.Match(alert) {
+			return false
+		}
+	}
+	return true
+}
+
+// PointFilter matches alerts with an area (polygon or circle) covering the
+// point.
+type PointFilter Point
+
+// Match returns true if any area of the alert contains the point.
+func (f PointFilter) Match(alert *cap.Alert) bool {
+	return matchShapes(alert, func(s Shape) bool {
+		return s.Contains(Point(f))
+	})
+}
+
+// BoundsFilter matches alerts with an area (polygon or circle) overlapping the
+// bounding box.
+type BoundsFilter Bounds
+
+// Match returns true if any area of the alert overlaps the bounding box.
+func (f BoundsFilter) Match(alert *cap.Alert) bool {
+	rect := Bounds(f).Polygon()
+	return matchShapes(alert, func(s Shape) bool {
+		return s.IntersectsPolygon(rect)
+	})
+}
+
+// PolygonFilter matches alerts with an area (polygon or circle) overlapping the
+// polygon.
+type PolygonFilter Polygon
+
+// Match returns true if any area of the alert overlaps the polygon.
+func (f PolygonFilter) Match(alert *cap.Alert) bool {
+	return matchShapes(alert, func(s Shape) bool {
+		return s.IntersectsPolygon(Polygon(f))
+	})
+}
+
+// matchShapes returns true if the test returns true for any shape of any area
+// of the alert. Areas without usable geometry never match.
+func matchShapes(alert *cap.Alert, test func(Shape) bool) bool {
+	for i := range alert.Info {
+		for j := range alert.Info[i].Area {
+			shapes, _ := AreaShapes(&alert.Info[i].Area[j])
+			for _, s := range shapes {
+				if test(s) {
+					return true
+				}
+			}
+		}
+	}
+	return false
+}
+
+// subscriber is an output channel receiving the alerts matched by its filter.
+type subscriber struct {
+	filter  Filter          // Filter of alerts to deliver
+	ch      chan *cap.Alert // Alert output channel
+	dropped int             // Count of alerts dropped because the channel was full
+}
+
+// Subscribe returns an Alert output channel receiving every forwarded alert
+// matched by the filter (in addition to the channel returned by Start). A nil
+// filter matches all alerts. Alerts are dropped (counted on the status page,
+// and logged if LogControl is enabled) while the channel is full, so a slow
+// subscriber never delays the client or the other subscribers.
+func (c *Client) Subscribe(filter Filter) chan *cap.Alert {
+	sub := &subscriber{filter: filter, ch: make(chan *cap.Alert, 16)}
+	c.mu.Lock()
+	c.subscribers = append(c.subscribers, sub)
+	c.mu.Unlock()
+	return sub.ch
+}
+
+// publish passes the alert to every subscriber with a matching filter,
+// dropping it for subscribers whose channel is full.
+func (c *Client) publish(alert *cap.Alert) {
+	c.mu.Lock()
+	subs := c.subscribers
+	c.mu.Unlock()
+	for i, sub := range subs {
+		if sub.filter != nil && !sub.filter.Match(alert) {
+			continue
+		}
+		select {
+		case sub.ch <- alert:
+		default:
+			c.mu.Lock()
+			sub.dropped++
+			dropped := sub.dropped
+			c.mu.Unlock()
+			if c.LogControl {
+				log.Printf("CONTROL [ERROR]  Subscriber %d channel is full; dropped %s (%d dropped)\n", i+1, alert.Identifier, dropped)
+			}
+		}
+	}
+}
@@ -0,0 +1,282 @@
+// Copyright (c) 2019 Tanner Ryan. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package naads
+
+import (
+	"errors"
+	"math"
+	"strconv"
+	"strings"
+
+	"github.com/thetannerryan/cap"
+)
+
+const earthRadius = 6371.0088 // mean radius of the Earth (km)
+
+// Point is a WGS 84 coordinate, as used by CAP polygons and circles.
+type Point struct {
+	Lat float64 // Latitude (degrees)
+	Lon float64 // Longitude (degrees)
+}
+
+// Bounds is a latitude/longitude bounding box.
+type Bounds struct {
+	MinLat float64 // Southern edge (degrees)
+	MinLon float64 // Western edge (degrees)
+	MaxLat float64 // Northern edge (degrees)
+	MaxLon float64 // Eastern edge (degrees)
+}
+
+// Polygon is a ring of points. CAP polygons are closed (the first and last
+// points are equal), but unclosed rings are treated as implicitly closed.
+type Polygon []Point
+
+// Circle is a point and a radius, as used by CAP circles.
+type Circle struct {
+	Center Point   // Center of the circle
+	Radius float64 // Radius of the circle (km)
+}
+
+// Shape is a geometry of a CAP area (either a Polygon or a Circle).
+type Shape interface {
+	Contains(p Point) bool          // Contains reports if the point lies within the shape
+	Bounds() Bounds                 // Bounds returns the bounding box of the shape
+	IntersectsPolygon(Polygon) bool // IntersectsPolygon reports if the shape overlaps the polygon
+}
+
+// parsePoint parses a "lat,lon" pair.
+func parsePoint(val string) (Point, error) {
+	pair := strings.Split(val, ",")
+	if len(pair) != 2 {
+		return Point{}, errors.New("Error: illegal coordinate pair " + val)
+	}
+	lat, err := strconv.ParseFloat(strings.TrimSpace(pair[0]), 64)
+	if err != nil {
+		return Point{}, errors.New("Error: illegal latitude in " + val)
+	}
+	lon, err := strconv.ParseFloat(strings.TrimSpace(pair[1]), 64)
+	if err != nil {
+		return Point{}, errors.New("Error: illegal longitude in " + val)
+	}
+	return Point{Lat: lat, Lon: lon}, nil
+}
+
+// ParsePolygon parses a CAP polygon: a whitespace delimited list of "lat,lon"
+// pairs.
+func ParsePolygon(val string) (Polygon, error) {
+	var poly Polygon
+	for _, pair := range strings.Fields(val) {
+		p, err := parsePoint(pair)
+		if err != nil {
+			return nil, err
+		}
+		poly = append(poly, p)
+	}
+	if len(poly) < 3 {
+		return nil, errors.New("Error: polygon requires at least 3 points")
+	}
+	return poly, nil
+}
+
+// ParseCircle parses a CAP circle: a "lat,lon" pair followed by a space and a
+// radius in kilometers.
+func ParseCircle(val string) (Circle, error) {
+	fields := strings.Fields(val)
+	if len(fields) != 2 {
+		return Circle{}, errors.New("Error: illegal circle " + val)
+	}
+	center, err := parsePoint(fields[0])
+	if err != nil {
+		return Circle{}, err
+	}
+	radius, err := strconv.ParseFloat(fields[1], 64)
+	if err != nil || radius < 0 {
+		return Circle{}, errors.New("Error: illegal radius in circle " + val)
+	}
+	return Circle{Center: center, Radius: radius}, nil
+}
+
+// AreaShapes returns the normalized shapes (polygon and circles) of the CAP
+// area. Shapes with fatal geometry problems are skipped; the first fatal problem
+// is returned alongside the shapes that are usable.
+func AreaShapes(area *cap.Area) ([]Shape, error) {
+	geometry, problems := ValidateArea(area)
+	for _, problem := range problems {
+		if problem.Fatal {
+			return geometry.Shapes(), problem
+		}
+	}
+	return geometry.Shapes(), nil
+}
+
+// Contains reports if the point lies within the bounds.
+func (b Bounds) Contains(p Point) bool {
+	return p.Lat >= b.MinLat && p.Lat <= b.MaxLat && p.Lon >= b.MinLon && p.Lon <= b.MaxLon
+}
+
+// Intersects reports if the two bounding boxes overlap.
+func (b Bounds) Intersects(o Bounds) bool {
+	return b.MinLat <= o.MaxLat && o.MinLat <= b.MaxLat && b.MinLon <= o.MaxLon && o.MinLon <= b.MaxLon
+}
+
+// Extend returns the smallest bounds covering both bounding boxes.
+func (b Bounds) Extend(o Bounds) Bounds {
+	return Bounds{
+		MinLat: math.Min(b.MinLat, o.MinLat),
+		MinLon: math.Min(b.MinLon, o.MinLon),
+		MaxLat: math.Max(b.MaxLat, o.MaxLat),
+		MaxLon: math.Max(b.MaxLon, o.MaxLon),
+	}
+}
+
+// Polygon returns the bounds as a closed rectangular polygon.
+func (b Bounds) Polygon() Polygon {
+	return Polygon{
+		{Lat: b.MinLat, Lon: b.MinLon},
+		{Lat: b.MinLat, Lon: b.MaxLon},
+		{Lat: b.MaxLat, Lon: b.MaxLon},
+		{Lat: b.MaxLat, Lon: b.MinLon},
+		{Lat: b.MinLat, Lon: b.MinLon},
+	}
+}
+
+// Contains reports if the point lies within the polygon (even-odd rule).
+func (poly Polygon) Contains(p Point) bool {
+	inside := false
+	for i, j := 0, len(poly)-1; i < len(poly); j, i = i, i+1 {
+		a, b := poly[i], poly[j]
+		if (a.Lat > p.Lat) != (b.Lat > p.Lat) &&
+			p.Lon < (b.Lon-a.Lon)*(p.Lat-a.Lat)/(b.Lat-a.Lat)+a.Lon {
+			inside = !inside
+		}
+	}
+	return inside
+}
+
+// Bounds returns the bounding box of the polygon.
+func (poly Polygon) Bounds() Bounds {
+	if len(poly) == 0 {
+		return Bounds{}
+	}
+	b := Bounds{MinLat: poly[0].Lat, MinLon: poly[0].Lon, MaxLat: poly[0].Lat, MaxLon: poly[0].Lon}
+	for _, p := range poly[1:] {
+		b.MinLat = math.Min(b.MinLat, p.Lat)
+		b.MinLon = math.Min(b.MinLon, p.Lon)
+		b.MaxLat = math.Max(b.MaxLat, p.Lat)
+		b.MaxLon = math.Max(b.MaxLon, p.Lon)
+	}
+	return b
+}
+
+// IntersectsPolygon reports if the two polygons overlap: either polygon
+// contains a vertex of the other, or any of their edges cross.
+func (poly Polygon) IntersectsPolygon(other Polygon) bool {
+	if len(poly) == 0 || len(other) == 0 || !poly.Bounds().Intersects(other.Bounds()) {
+		return false
+	}
+	if poly.Contains(other[0]) || other.Contains(poly[0]) {
+		return true
+	}
+	for i, j := 0, len(poly)-1; i < len(poly); j, i = i, i+1 {
+		for k, l := 0, len(other)-1; k < len(other); l, k = k, k+1 {
+			if segmentsIntersect(poly[j], poly[i], other[l], other[k]) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// Contains reports if the point lies within the circle (great-circle
+// distance).
+func (c Circle) Contains(p Point) bool {
+	return Distance(c.Center, p) <= c.Radius
+}
+
+// Bounds returns the bounding box of the circle.
+func (c Circle) Bounds() Bounds {
+	dLat := c.Radius / earthRadius * 180 / math.Pi
+	dLon := 180.0
+	if cos := math.Cos(c.Center.Lat * math.Pi / 180); cos > 1e-9 {
+		dLon = math.Min(dLat/cos, 180)
+	}
+	return Bounds{
+		MinLat: math.Max(c.Center.Lat-dLat, -90),
+		MinLon: c.Center.Lon - dLon,
+		MaxLat: math.Min(c.Center.Lat+dLat, 90),
+		MaxLon: c.Center.Lon + dLon,
+	}
+}
+
+// IntersectsPolygon reports if the circle overlaps the polygon: the polygon
+// contains the center, or an edge of the polygon passes within the radius.
+func (c Circle) IntersectsPolygon(poly Polygon) bool {
+	if len(poly) == 0 || !c.Bounds().Intersects(poly.Bounds()) {
+		return false
+	}
+	if poly.Contains(c.Center) {
+		return true
+	}
+	for i, j := 0, len(poly)-1; i < len(poly); j, i = i, i+1 {
+		if segmentDistance(c.Center, poly[j], poly[i]) <= c.Radius {
+			return true
+		}
+	}
+	return false
+}
+
+// Distance returns the great-circle distance between two points (km).
+func Distance(a, b Point) float64 {
+	lat1 := a.Lat * math.Pi / 180
+	lat2 := b.Lat * math.Pi / 180
+	dLat := lat2 - lat1
+	dLon := (b.Lon - a.Lon) * math.Pi / 180
+	h := math.Sin(dLat/2)*math.Sin(dLat/2) + math.Cos(lat1)*math.Cos(lat2)*math.Sin(dLon/2)*math.Sin(dLon/2)
+	return 2 * earthRadius * math.Asin(math.Min(1, math.Sqrt(h)))
+}
+
+// segmentDistance returns the approximate distance (km) from the point to the
+// segment a-b, using an equirectangular projection centered on the point.
+func segmentDistance(p, a, b Point) float64 {
+	scale := earthRadius * math.Pi / 180
+	cos := math.Cos(p.Lat * math.Pi / 180)
+	ax, ay := (a.Lon-p.Lon)*cos*scale, (a.Lat-p.Lat)*scale
+	bx, by := (b.Lon-p.Lon)*cos*scale, (b.Lat-p.Lat)*scale
+	dx, dy := bx-ax, by-ay
+	t := 0.0
+	if l := dx*dx + dy*dy; l > 0 {
+		t = math.Max(0, math.Min(1, -(ax*dx+ay*dy)/l))
+	}
+	return math.Hypot(ax+t*dx, ay+t*dy)
+}
+
+// orientation returns the sign of the cross product (b-a) x (c-a).
+func orientation(a, b, c Point) int {
+	v := (b.Lon-a.Lon)*(c.Lat-a.Lat) - (b.Lat-a.Lat)*(c.Lon-a.Lon)
+	switch {
+	case v > 0:
+		return 1
+	case v < 0:
+		return -1
+	}
+	return 0
+}
+
+// onSegment reports if the collinear point c lies on the segment a-b.
+func onSegment(a, b, c Point) bool {
+	return math.Min(a.Lon, b.Lon) <= c.Lon && c.Lon <= math.Max(a.Lon, b.Lon) &&
+		math.Min(a.Lat, b.Lat) <= c.Lat && c.Lat <= math.Max(a.Lat, b.Lat)
+}
+
+// segmentsIntersect reports if the segments a-b and c-d share a point.
+func segmentsIntersect(a, b, c, d Point) bool {
+	o1, o2 := orientation(a, b, c), orientation(a, b, d)
+	o3, o4 := orientation(c, d, a), orientation(c, d, b)
+	if o1 != o2 && o3 != o4 {
+		return true
+	}
+	return (o1 == 0 && onSegment(a, b, c)) || (o2 == 0 && onSegment(a, b, d)) ||
+		(o3 == 0 && onSegment(c, d, a)) || (o4 == 0 && onSegment(c, d, b))
+}
@@ -0,0 +1,149 @@
+// Copyright (c) 2019 Tanner Ryan. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package naads
+
+import (
+	"math"
+
+	"github.com/thetannerryan/cap"
+)
+
+const circleSegments = 64 // number of segments used to approximate a circle
+
+// FeatureCollection is a GeoJSON (RFC 7946) feature collection.
+type FeatureCollection struct {
+	Type     string     `json:"type"`
+	Features []*Feature `json:"features"`
+}
+
+// Feature is a GeoJSON feature describing one area of an alert's Info block.
+type Feature struct {
+	Type       string             `json:"type"`
+	Geometry   *Geometry          `json:"geometry"`
+	Properties *FeatureProperties `json:"properties"`
+}
+
+// Geometry is a GeoJSON Polygon or MultiPolygon geometry. Coordinates are
+// [longitude, latitude] positions.
+type Geometry struct {
+	Type        string      `json:"type"`
+	Coordinates interface{} `json:"coordinates"`
+}
+
+// FeatureProperties are the properties of an alert area feature.
+type FeatureProperties struct {
+	Identifier string `json:"identifier"`
+	Sender     string `json:"sender"`
+	Sent       string `json:"sent"`
+	Status     string `json:"status"`
+	MsgType    string `json:"msgType"`
+	Language   string `json:"language"`
+	Event      string `json:"event"`
+	Severity   string `json:"severity"`
+	Urgency    string `json:"urgency"`
+	Certainty  string `json:"certainty"`
+	Headline   string `json:"headline"`
+	Effective  string `json:"effective,omitempty"`
+	Expires    string `json:"expires,omitempty"`
+	AreaDesc   string `json:"areaDesc"`
+}
+
+// GeoJSON converts the areas of the alerts into a GeoJSON FeatureCollection,
+// with one feature per area of every Info block. Circles are approximated as
+// polygons. Areas without a polygon or circle (geocode only) are included with
+// a null geometry.
+func GeoJSON(alerts ...*cap.Alert) *FeatureCollection {
+	fc := &FeatureCollection{Type: "FeatureCollection", Features: []*Feature{}}
+	for _, alert := range alerts {
+		for i := range alert.Info {
+			info := &alert.Info[i]
+			for j := range info.Area {
+				area := &info.Area[j]
+				fc.Features = append(fc.Features, &Feature{
+					Type:       "Feature",
+					Geometry:   areaGeometry(area),
+					Properties: featureProperties(alert, info, area),
+				})
+			}
+		}
+	}
+	return fc
+}
+
+// featureProperties returns the feature properties of the area.
+func featureProperties(alert *cap.Alert, info *cap.Info, area *cap.Area) *FeatureProperties {
+	props := &FeatureProperties{
+		Identifier: alert.Identifier,
+		Sender:     alert.Sender,
+		Sent:       alert.Sent.String(),
+		Status:     alert.Status.String(),
+		MsgType:    alert.MsgType.String(),
+		Language:   info.Language,
+		Event:      info.Event,
+		Severity:   info.Severity.String(),
+		Urgency:    info.Urgency.String(),
+		Certainty:  info.Certainty.String(),
+		Headline:   info.Headline,
+		AreaDesc:   area.AreaDesc,
+	}
+	if !info.Effective.Time().IsZero() {
+		props.Effective = info.Effective.String()
+	}
+	if !info.Expires.Time().IsZero() {
+		props.Expires = info.Expires.String()
+	}
+	return props
+}
+
+// areaGeometry returns the GeoJSON geometry of the area, or nil if the area has
+// no usable polygon or circle.
+func areaGeometry(area *cap.Area) *Geometry {
+	shapes, _ := AreaShapes(area)
+	var polygons [][][][2]float64
+	for _, s := range shapes {
+		switch shape := s.(type) {
+		case Polygon:
+			polygons = append(polygons, [][][2]float64{ringPositions(shape)})
+		case Circle:
+			polygons = append(polygons, [][][2]float64{ringPositions(shape.Polygon(circleSegments))})
+		}
+	}
+	switch len(polygons) {
+	case 0:
+		return nil
+	case 1:
+		return &Geometry{Type: "Polygon", Coordinates: polygons[0]}
+	}
+	return &Geometry{Type: "MultiPolygon", Coordinates: polygons}
+}
+
+// ringPositions converts the polygon to a closed ring of GeoJSON positions.
+func ringPositions(poly Polygon) [][2]float64 {
+	ring := make([][2]float64, 0, len(poly)+1)
+	for _, p := range poly {
+		ring = append(ring, [2]float64{p.Lon, p.Lat})
+	}
+	if len(poly) > 0 && poly[0] != poly[len(poly)-1] {
+		ring = append(ring, [2]float64{poly[0].Lon, poly[0].Lat})
+	}
+	return ring
+}
+
+// Polygon approximates the circle as a closed, counterclockwise polygon with
+// the given number of segments.
+func (c Circle) Polygon(segments int) Polygon {
+	lat1 := c.Center.Lat * math.Pi / 180
+	lon1 := c.Center.Lon * math.Pi / 180
+	d := c.Radius / earthRadius
+	poly := make(Polygon, 0, segments+1)
+	for i := 0; i < segments; i++ {
+		// negative bearings produce a counterclockwise ring
+		bearing := -2 * math.Pi * float64(i) / float64(segments)
+		lat2 := math.Asin(math.Sin(lat1)*math.Cos(d) + math.Cos(lat1)*math.Sin(d)*math.Cos(bearing))
+		lon2 := lon1 + math.Atan2(math.Sin(bearing)*math.Sin(d)*math.Cos(lat1), math.Cos(d)-math.Sin(lat1)*math.Sin(lat2))
+		poly = append(poly, Point{Lat: lat2 * 180 / math.Pi, Lon: lon2 * 180 / math.Pi})
+	}
+	return append(poly, poly[0])
+}
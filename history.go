@@ -0,0 +1,165 @@
+// Copyright (c) 2019 Tanner Ryan. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package naads
+
+import (
+	"regexp"
+	"sort"
+	"sync"
+)
+
+const (
+	defaultHistorySize  = 1024     // default number of messages retained
+	defaultHistoryBytes = 64 << 20 // default total size of the messages retained (bytes)
+)
+
+// derefURIPattern matches the derefUri elements of a CAP document, capturing
+// the start tag, the embedded data and the end tag.
+var derefURIPattern = regexp.MustCompile(`(<(?:[\w.-]+:)?derefUri\b[^>]*>)([^<]*)(</(?:[\w.-]+:)?derefUri>)`)
+
+// history retains the most recently received messages, keyed by identifier.
+type history struct {
+	mu       sync.Mutex          // Mutex protecting the history
+	order    []string            // Identifiers in order of arrival (oldest first)
+	messages map[string]*Message // Retained messages keyed by identifier
+	total    int                 // Total size of the retained messages (bytes)
+}
+
+// add retains the message, discarding the oldest messages beyond the size or
+// the total number of bytes.
+func (h *history) add(msg *Message, size, bytes int) {
+	if size <= 0 {
+		size = defaultHistorySize
+	}
+	if bytes <= 0 {
+		bytes = defaultHistoryBytes
+	}
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if h.messages == nil {
+		h.messages = make(map[string]*Message)
+	}
+	id := msg.Alert.Identifier
+	if old, ok := h.messages[id]; ok {
+		h.total -= messageBytes(old)
+	} else {
+		h.order = append(h.order, id)
+	}
+	h.messages[id] = msg
+	h.total += messageBytes(msg)
+	for len(h.order) > size || (h.total > bytes && len(h.order) > 1) {
+		h.total -= messageBytes(h.messages[h.order[0]])
+		delete(h.messages, h.order[0])
+		h.order = h.order[1:]
+	}
+}
+
+// messageBytes returns the size of the XML and resource data held by the
+// message.
+func messageBytes(msg *Message) int {
+	n := len(msg.Raw)
+	for _, r := range msg.Resources {
+		n += len(r.Data)
+	}
+	return n
+}
+
+// stripSaved returns a copy of the message whose XML omits the embedded data
+// of the resources saved to a file. The derefUri elements of the document
+// appear in the order of the decoded resources.
+func stripSaved(msg *Message) *Message {
+	var decoded []*Resource
+	for _, r := range msg.Resources {
+		if !r.Remote {
+			decoded = append(decoded, r)
+		}
+	}
+	i := 0
+	raw := derefURIPattern.ReplaceAllFunc(msg.Raw, func(m []byte) []byte {
+		parts := derefURIPattern.FindSubmatch(m)
+		if len(parts[2]) == 0 {
+			return m
+		}
+		i++
+		if i > len(decoded) || decoded[i-1].Path == "" {
+			return m
+		}
+		return append(append([]byte(nil), parts[1]...), parts[3]...)
+	})
+	if len(raw) == len(msg.Raw) {
+		return msg
+	}
+	stripped := *msg
+	stripped.Raw = raw
+	return &stripped
+}
+
+// get returns the retained message with the identifier.
+func (h *history) get(identifier string) (*Message, bool) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	msg, ok := h.messages[identifier]
+	return msg, ok
+}
+
+// Lookup returns a recently received message by alert identifier, including
+// its original XML and processing metadata (such as the signature
+// verification result). Heartbeats are not retained. When ResourceDir is set,
+// the embedded data of the resources saved to it is removed from the retained
+// XML (whose signature then no longer verifies).
+func (c *Client) Lookup(identifier string) (*Message, bool) {
+	return c.history.get(identifier)
+}
+
+// chain returns the retained messages connected to the identifier through
+// references, in either direction.
+func (h *history) chain(identifier string) []*Message {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if _, ok := h.messages[identifier]; !ok {
+		return nil
+	}
+
+	// identifiers of the retained messages referencing each identifier
+	referencedBy := make(map[string][]string)
+	for id, msg := range h.messages {
+		for _, ref := range referencedIdentifiers(msg.Alert) {
+			referencedBy[ref] = append(referencedBy[ref], id)
+		}
+	}
+
+	var chain []*Message
+	seen := map[string]bool{identifier: true}
+	queue := []string{identifier}
+	for len(queue) > 0 {
+		id := queue[0]
+		queue = queue[1:]
+		msg, ok := h.messages[id]
+		if !ok {
+			continue
+		}
+		chain = append(chain, msg)
+		for _, next := range append(referencedIdentifiers(msg.Alert), referencedBy[id]...) {
+			if !seen[next] {
+				seen[next] = true
+				queue = append(queue, next)
+			}
+		}
+	}
+	return chain
+}
+
+// Chain returns the reference chain of a recently received message: the
+// messages it references (directly or through the messages they reference)
+// and the updates and cancellations referencing them, oldest first. The
+// message itself is included; messages that are no longer retained are
+// omitted. Nil is returned if the message is not retained.
+func (c *Client) Chain(identifier string) []*Message {
+	chain := c.history.chain(identifier)
+	sort.SliceStable(chain, func(i, j int) bool {
+		return chain[i].Alert.Sent.Time().Before(chain[j].Alert.Sent.Time())
+	})
+	return chain
+}
@@ -5,16 +5,43 @@
 package naads
 
 import (
+	"crypto/subtle"
+	"crypto/tls"
+	"encoding/json"
+	"errors"
 	"fmt"
 	"html/template"
 	"log"
+	"net"
 	"net/http"
+	"net/url"
 	"strconv"
+	"strings"
 	"time"
+
+	"github.com/thetannerryan/cap"
 )
 
-// HTTP starts an endpoint for viewing the status of the NAADS client.
+// HTTPConfig represents the configuration of the HTTP status endpoint.
+type HTTPConfig struct {
+	Address  string   // Address to bind to (empty binds all interfaces)
+	CertFile string   // TLS certificate file (TLS is enabled when set; KeyFile is then required)
+	KeyFile  string   // TLS private key file
+	Username string   // HTTP basic auth username (authentication is required when a username or token is set)
+	Password string   // HTTP basic auth password
+	Tokens   []string // Accepted bearer tokens
+	RSS      bool     // Indicator to publish the RSS feed of active alerts (the Atom feed is always published)
+}
+
+// HTTP starts an endpoint for viewing the status of the NAADS client. The
+// endpoint is configured by the client's HTTPConfig. An invalid TLS
+// configuration is logged and the endpoint is not started; a partial TLS
+// configuration is never served in plain HTTP.
 func (c *Client) HTTP(port int) {
+	if err := c.HTTPConfig.validateTLS(); err != nil {
+		log.Printf("CONTROL [ERROR]  Unable to start HTTP endpoint: %s\n", err)
+		return
+	}
 	go func() {
 		// parse status page
 		page, err := template.ParseFiles("status.html")
@@ -24,8 +51,8 @@ func (c *Client) HTTP(port int) {
 
 		mux := http.NewServeMux()
 		server := &http.Server{
-			Addr:         ":" + strconv.Itoa(port),
-			Handler:      mux,
+			Addr:         net.JoinHostPort(c.HTTPConfig.Address, strconv.Itoa(port)),
+			Handler:      c.HTTPConfig.authenticate(mux),
 			ReadTimeout:  5 * time.Second,
 			WriteTimeout: 10 * time.Second,
 		}
@@ -39,19 +66,261 @@ func (c *Client) HTTP(port int) {
 			}
 		})
 
+		// register GeoJSON route (active alerts)
+		mux.HandleFunc("/alerts.geojson", func(w http.ResponseWriter, r *http.Request) {
+			data, err := json.Marshal(GeoJSON(c.Active()...))
+			if err != nil {
+				http.Error(w, err.Error(), http.StatusInternalServerError)
+				return
+			}
+			w.Header().Set("Content-Type", "application/geo+json")
+			w.Write(data)
+		})
+
+		// register KML route (active alerts)
+		mux.HandleFunc("/alerts.kml", func(w http.ResponseWriter, r *http.Request) {
+			data, err := KML(c.Active()...)
+			if err != nil {
+				http.Error(w, err.Error(), http.StatusInternalServerError)
+				return
+			}
+			w.Header().Set("Content-Type", "application/vnd.google-earth.kml+xml")
+			w.Header().Set("Content-Disposition", `attachment; filename="naads.kml"`)
+			w.Write(data)
+		})
+
+		// register Atom route (active alerts)
+		mux.HandleFunc("/alerts.atom", func(w http.ResponseWriter, r *http.Request) {
+			data, err := Atom(baseURL(r), r.URL.Query().Get("lang"), c.feedAlerts(r.URL.Query())...)
+			if err != nil {
+				http.Error(w, err.Error(), http.StatusInternalServerError)
+				return
+			}
+			w.Header().Set("Content-Type", "application/atom+xml")
+			w.Write(data)
+		})
+
+		// register RSS route (active alerts)
+		if c.HTTPConfig.RSS {
+			mux.HandleFunc("/alerts.rss", func(w http.ResponseWriter, r *http.Request) {
+				data, err := RSS(baseURL(r), r.URL.Query().Get("lang"), c.feedAlerts(r.URL.Query())...)
+				if err != nil {
+					http.Error(w, err.Error(), http.StatusInternalServerError)
+					return
+				}
+				w.Header().Set("Content-Type", "application/rss+xml")
+				w.Write(data)
+			})
+		}
+
+		// register retained message routes: original CAP XML
+		// (/alerts/<identifier>.xml), JSON (/alerts/<identifier>.json) and
+		// reference chain (/alerts/<identifier>/references)
+		mux.HandleFunc("/alerts/", func(w http.ResponseWriter, r *http.Request) {
+			name := strings.TrimPrefix(r.URL.Path, "/alerts/")
+			switch {
+			case strings.HasSuffix(name, "/references"):
+				chain := c.Chain(strings.TrimSuffix(name, "/references"))
+				if chain == nil {
+					http.NotFound(w, r)
+					return
+				}
+				data, err := json.Marshal(referenceChain(baseURL(r), chain))
+				if err != nil {
+					http.Error(w, err.Error(), http.StatusInternalServerError)
+					return
+				}
+				w.Header().Set("Content-Type", "application/json")
+				w.Write(data)
+			case strings.HasSuffix(name, ".xml"):
+				msg, ok := c.Lookup(strings.TrimSuffix(name, ".xml"))
+				if !ok {
+					http.NotFound(w, r)
+					return
+				}
+				w.Header().Set("Content-Type", "application/cap+xml")
+				w.Write(msg.Raw)
+			case strings.HasSuffix(name, ".json"):
+				msg, ok := c.Lookup(strings.TrimSuffix(name, ".json"))
+				if !ok {
+					http.NotFound(w, r)
+					return
+				}
+				data, err := json.Marshal(msg.Alert)
+				if err != nil {
+					http.Error(w, err.Error(), http.StatusInternalServerError)
+					return
+				}
+				w.Header().Set("Content-Type", "application/json")
+				w.Write(data)
+			default:
+				http.NotFound(w, r)
+			}
+		})
+
 		// start endpoint
+		if c.HTTPConfig.CertFile != "" {
+			log.Fatalln(server.ListenAndServeTLS(c.HTTPConfig.CertFile, c.HTTPConfig.KeyFile))
+		}
 		log.Fatalln(server.ListenAndServe())
 	}()
 }
 
+// validateTLS returns an error if only one of CertFile and KeyFile is set, or
+// if the certificate and key cannot be loaded.
+func (h *HTTPConfig) validateTLS() error {
+	if (h.CertFile == "") != (h.KeyFile == "") {
+		return errors.New("Error: both CertFile and KeyFile are required to enable TLS")
+	}
+	if h.CertFile == "" {
+		return nil
+	}
+	_, err := tls.LoadX509KeyPair(h.CertFile, h.KeyFile)
+	return err
+}
+
+// authenticate wraps the handler, requiring HTTP basic auth or a bearer token
+// if either is configured.
+func (h *HTTPConfig) authenticate(next http.Handler) http.Handler {
+	if h.Username == "" && len(h.Tokens) == 0 {
+		return next
+	}
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if h.authorized(r) {
+			next.ServeHTTP(w, r)
+			return
+		}
+		if h.Username != "" {
+			w.Header().Set("WWW-Authenticate", `Basic realm="NAADS", charset="UTF-8"`)
+		} else {
+			w.Header().Set("WWW-Authenticate", `Bearer realm="NAADS"`)
+		}
+		http.Error(w, http.StatusText(http.StatusUnauthorized), http.StatusUnauthorized)
+	})
+}
+
+// authorized returns true if the request carries valid credentials.
+func (h *HTTPConfig) authorized(r *http.Request) bool {
+	if h.Username != "" {
+		if user, pass, ok := r.BasicAuth(); ok {
+			// evaluate both comparisons to avoid leaking which one failed
+			u := subtle.ConstantTimeCompare([]byte(user), []byte(h.Username))
+			p := subtle.ConstantTimeCompare([]byte(pass), []byte(h.Password))
+			if u&p == 1 {
+				return true
+			}
+		}
+	}
+	auth := r.Header.Get("Authorization")
+	if len(auth) > 7 && strings.EqualFold(auth[:7], "Bearer ") {
+		token := []byte(strings.TrimSpace(auth[7:]))
+		for _, t := range h.Tokens {
+			if t != "" && subtle.ConstantTimeCompare(token, []byte(t)) == 1 {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// feedAlerts returns the active alerts matching the query parameters of a
+// feed request: lang (language of an Info block, e.g. "fr"), province (comma
+// separated 2, 4 or 7 digit SGC codes or province abbreviations, e.g.
+// "35,3506,QC") and status (e.g. "Actual").
+func (c *Client) feedAlerts(query url.Values) []*cap.Alert {
+	var filters AllFilter
+	if lang := query.Get("lang"); lang != "" {
+		filters = append(filters, FilterFunc(func(alert *cap.Alert) bool {
+			return feedInfo(alert, lang) != nil
+		}))
+	}
+	if province := query.Get("province"); province != "" {
+		var codes GeocodeFilter
+		for _, code := range strings.Split(province, ",") {
+			code = strings.TrimSpace(code)
+			if sgc, ok := ProvinceCode(code); ok {
+				code = sgc
+			}
+			if code != "" {
+				codes = append(codes, code)
+			}
+		}
+		filters = append(filters, codes)
+	}
+	if status := query.Get("status"); status != "" {
+		filters = append(filters, FilterFunc(func(alert *cap.Alert) bool {
+			return strings.EqualFold(alert.Status.String(), status)
+		}))
+	}
+
+	var alerts []*cap.Alert
+	for _, alert := range c.Active() {
+		if filters.Match(alert) {
+			alerts = append(alerts, alert)
+		}
+	}
+	return alerts
+}
+
+// chainlink is a message of a reference chain, as returned by the references
+// endpoint.
+type chainlink struct {
+	Identifier string   `json:"identifier"`
+	Sender     string   `json:"sender"`
+	Sent       string   `json:"sent"`
+	MsgType    string   `json:"msgType"`
+	Class      string   `json:"class"`
+	References []string `json:"references"`
+	XML        string   `json:"xml"`
+	JSON       string   `json:"json"`
+}
+
+// referenceChain describes the messages of the reference chain.
+func referenceChain(base string, chain []*Message) []chainlink {
+	links := make([]chainlink, 0, len(chain))
+	for _, msg := range chain {
+		link := chainlink{
+			Identifier: msg.Alert.Identifier,
+			Sender:     msg.Alert.Sender,
+			Sent:       msg.Alert.Sent.String(),
+			MsgType:    msg.Alert.MsgType.String(),
+			Class:      msg.Class.String(),
+			References: referencedIdentifiers(msg.Alert),
+			XML:        alertURL(base, msg.Alert.Identifier),
+		}
+		if link.References == nil {
+			link.References = []string{}
+		}
+		link.JSON = strings.TrimSuffix(link.XML, ".xml") + ".json"
+		links = append(links, link)
+	}
+	return links
+}
+
+// baseURL returns the scheme and host of the request, for constructing
+// absolute links.
+func baseURL(r *http.Request) string {
+	if r.TLS != nil {
+		return "https://" + r.Host
+	}
+	return "http://" + r.Host
+}
+
 // status is for rendering the HTTP status page
 type status struct {
-	Version    string
-	Uptime     string
-	Time       string
-	UTCTime    string
-	FeedStatus []feedstatus
-	FeedConfig []feedconfig
+	Version     string
+	Uptime      string
+	Time        string
+	UTCTime     string
+	Signatures  string
+	Signer      string
+	SignerStyle string
+	Classes     []string
+	FeedStatus  []feedstatus
+	FeedConfig  []feedconfig
+	Routing     []routing
+	Channels    []channelstatus
+	Outputs     []outputstatus
 }
 
 // feedstatus is for rendering the HTTP status page
@@ -62,10 +331,7 @@ type feedstatus struct {
 	LastMsg         string
 	LastMsgTime     string
 	CountDisconnect string
-	CountAlert      string
-	CountHeartbeat  string
-	CountTest       string
-	CountUnknown    string
+	Counts          []string
 }
 
 // feedconfig is for rendering the HTTP status page
@@ -80,6 +346,28 @@ type feedconfig struct {
 	LogHeartbeat    string
 }
 
+// routing is for rendering the HTTP status page
+type routing struct {
+	Class string
+	Route string
+}
+
+// channelstatus is for rendering the HTTP status page
+type channelstatus struct {
+	Name    string
+	Queued  string
+	Dropped string
+}
+
+// outputstatus is for rendering the HTTP status page
+type outputstatus struct {
+	Name    string
+	Queued  string
+	Sent    string
+	Retried string
+	Failed  string
+}
+
 // generateStatus generates a status struct for rendering the status template.
 func (c *Client) generateStatus() *status {
 	// system data
@@ -117,10 +405,9 @@ func (c *Client) generateStatus() *status {
 			status.LastMsgTime = "(" + strconv.Itoa(diff) + " seconds ago)"
 		}
 		status.CountDisconnect = strconv.Itoa(f.countDisconnect)
-		status.CountAlert = strconv.Itoa(f.countAlert)
-		status.CountHeartbeat = strconv.Itoa(f.countHeartbeat)
-		status.CountTest = strconv.Itoa(f.countTest)
-		status.CountUnknown = strconv.Itoa(f.countUnknown)
+		for _, count := range f.counts {
+			status.Counts = append(status.Counts, strconv.Itoa(count))
+		}
 		feedStatus = append(feedStatus, status)
 
 		// feed config
@@ -148,13 +435,76 @@ func (c *Client) generateStatus() *status {
 		feedConfig = append(feedConfig, config)
 	}
 
+	// message classes and their routes
+	var classes []string
+	var routes []routing
+	for class := Class(0); class < classCount; class++ {
+		classes = append(classes, class.String())
+		routes = append(routes, routing{
+			Class: class.String(),
+			Route: c.route(class).String(),
+		})
+	}
+
+	// alert channels and the alerts dropped while they were full
+	c.mu.Lock()
+	channels := []channelstatus{{
+		Name:    "Output channel",
+		Queued:  strconv.Itoa(len(c.ch)),
+		Dropped: strconv.Itoa(c.countDropped),
+	}}
+	for i, sub := range c.subscribers {
+		channels = append(channels, channelstatus{
+			Name:    "Subscriber " + strconv.Itoa(i+1),
+			Queued:  strconv.Itoa(len(sub.ch)),
+			Dropped: strconv.Itoa(sub.dropped),
+		})
+	}
+	c.mu.Unlock()
+
+	// output delivery counters
+	var outputs []outputstatus
+	for _, o := range c.Outputs {
+		sent, retried, failed := o.counts()
+		outputs = append(outputs, outputstatus{
+			Name:    o.Name,
+			Queued:  strconv.Itoa(len(o.ch)),
+			Sent:    strconv.Itoa(sent),
+			Retried: strconv.Itoa(retried),
+			Failed:  strconv.Itoa(failed),
+		})
+	}
+
+	// signature verification
+	signatures := c.Signatures.String()
+	if c.Signatures != SignaturePass {
+		signatures += fmt.Sprintf(" (%d valid, %d invalid)", c.countValid, c.countInvalid)
+	}
+
+	// signing certificate (highlighted when approaching expiry)
+	signer, signerStyle := "N/A", ""
+	if c.signer != nil {
+		days := int(c.signer.NotAfter.Sub(currentTime).Hours() / 24)
+		signer = fmt.Sprintf("%s (expires %s, %d days)", c.signer.Subject.CommonName, c.signer.NotAfter.Format("Mon Jan 2 2006"), days)
+		if c.Trust != nil && c.Trust.expiring(c.signer, currentTime) {
+			signerStyle = "status-down"
+		}
+	}
+
 	// construct status struct
 	return &status{
-		Version:    version,
-		Uptime:     uptimeStr,
-		Time:       currentTime.Format("Mon Jan 2 2006 15:04:05 MST"),
-		UTCTime:    currentTime.UTC().Format("Mon Jan 2 2006 15:04:05 MST"),
-		FeedStatus: feedStatus,
-		FeedConfig: feedConfig,
+		Version:     version,
+		Uptime:      uptimeStr,
+		Time:        currentTime.Format("Mon Jan 2 2006 15:04:05 MST"),
+		UTCTime:     currentTime.UTC().Format("Mon Jan 2 2006 15:04:05 MST"),
+		Signatures:  signatures,
+		Signer:      signer,
+		SignerStyle: signerStyle,
+		Classes:     classes,
+		FeedStatus:  feedStatus,
+		FeedConfig:  feedConfig,
+		Routing:     routes,
+		Channels:    channels,
+		Outputs:     outputs,
 	}
 }
@@ -0,0 +1,241 @@
+// Copyright (c) 2019 Tanner Ryan. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package naads
+
+import (
+	"math"
+	"sync"
+	"time"
+
+	"github.com/thetannerryan/cap"
+)
+
+const defaultCellSize = 0.5 // default grid cell size of the spatial indexes (degrees)
+
+// cell is the key of a grid cell.
+type cell struct {
+	lat int // row of the cell
+	lon int // column of the cell
+}
+
+// grid maps latitude/longitude coordinates to grid cells.
+type grid float64
+
+// size returns the cell size of the grid, using the default if unset.
+func (g grid) size() float64 {
+	if g <= 0 {
+		return defaultCellSize
+	}
+	return float64(g)
+}
+
+// cell returns the cell containing the point.
+func (g grid) cell(p Point) cell {
+	return cell{lat: int(math.Floor(p.Lat / g.size())), lon: int(math.Floor(p.Lon / g.size()))}
+}
+
+// cells calls fn for every cell overlapping the bounds.
+func (g grid) cells(b Bounds, fn func(cell)) {
+	min := g.cell(Point{Lat: b.MinLat, Lon: b.MinLon})
+	max := g.cell(Point{Lat: b.MaxLat, Lon: b.MaxLon})
+	for lat := min.lat; lat <= max.lat; lat++ {
+		for lon := min.lon; lon <= max.lon; lon++ {
+			fn(cell{lat: lat, lon: lon})
+		}
+	}
+}
+
+// indexEntry is a single shape of an indexed alert.
+type indexEntry struct {
+	alert  *cap.Alert // Alert containing the shape
+	shape  Shape      // Polygon or circle of an area of the alert
+	bounds Bounds     // Bounding box of the shape
+}
+
+// Index is a grid based spatial index of alert areas, answering which alerts
+// cover a point without scanning every polygon. The zero value is an empty
+// index ready for use.
+type Index struct {
+	CellSize float64 // Size of the grid cells (degrees); defaults to 0.5 (must be set before use)
+
+	mu      sync.RWMutex             // Mutex protecting the index
+	cells   map[cell][]*indexEntry   // Entries overlapping each cell
+	entries map[string][]*indexEntry // Entries of each alert (by identifier)
+}
+
+// Add indexes every polygon and circle of the alert, replacing any alert
+// previously indexed with the same identifier.
+func (idx *Index) Add(alert *cap.Alert) {
+	idx.mu.Lock()
+	defer idx.mu.Unlock()
+	if idx.cells == nil {
+		idx.cells = make(map[cell][]*indexEntry)
+		idx.entries = make(map[string][]*indexEntry)
+	}
+	idx.remove(alert.Identifier)
+
+	g := grid(idx.CellSize)
+	for i := range alert.Info {
+		for j := range alert.Info[i].Area {
+			shapes, _ := AreaShapes(&alert.Info[i].Area[j])
+			for _, s := range shapes {
+				entry := &indexEntry{alert: alert, shape: s, bounds: s.Bounds()}
+				idx.entries[alert.Identifier] = append(idx.entries[alert.Identifier], entry)
+				g.cells(entry.bounds, func(c cell) {
+					idx.cells[c] = append(idx.cells[c], entry)
+				})
+			}
+		}
+	}
+}
+
+// Remove removes the alert with the identifier from the index.
+func (idx *Index) Remove(identifier string) {
+	idx.mu.Lock()
+	defer idx.mu.Unlock()
+	idx.remove(identifier)
+}
+
+// remove removes the alert with the identifier (lock must be held).
+func (idx *Index) remove(identifier string) {
+	g := grid(idx.CellSize)
+	for _, entry := range idx.entries[identifier] {
+		g.cells(entry.bounds, func(c cell) {
+			list := idx.cells[c]
+			for i := range list {
+				if list[i] == entry {
+					list = append(list[:i], list[i+1:]...)
+					break
+				}
+			}
+			if len(list) == 0 {
+				delete(idx.cells, c)
+			} else {
+				idx.cells[c] = list
+			}
+		})
+	}
+	delete(idx.entries, identifier)
+}
+
+// Query returns the indexed alerts with an area covering the point.
+func (idx *Index) Query(p Point) []*cap.Alert {
+	idx.mu.RLock()
+	defer idx.mu.RUnlock()
+	var alerts []*cap.Alert
+	seen := make(map[*cap.Alert]bool)
+	for _, entry := range idx.cells[grid(idx.CellSize).cell(p)] {
+		if seen[entry.alert] || !entry.bounds.Contains(p) || !entry.shape.Contains(p) {
+			continue
+		}
+		seen[entry.alert] = true
+		alerts = append(alerts, entry.alert)
+	}
+	return alerts
+}
+
+// Len returns the number of indexed alerts.
+func (idx *Index) Len() int {
+	idx.mu.RLock()
+	defer idx.mu.RUnlock()
+	return len(idx.entries)
+}
+
+// SiteIndex is a grid based spatial index of named sites (points), answering
+// which sites are covered by an alert. The zero value is an empty index ready
+// for use.
+type SiteIndex struct {
+	CellSize float64 // Size of the grid cells (degrees); defaults to 0.5 (must be set before use)
+
+	mu    sync.RWMutex             // Mutex protecting the index
+	sites map[string]Point         // Location of each site (by ID)
+	cells map[cell]map[string]bool // Sites within each cell
+}
+
+// Add adds (or moves) the site with the ID to the location.
+func (idx *SiteIndex) Add(id string, p Point) {
+	idx.mu.Lock()
+	defer idx.mu.Unlock()
+	if idx.sites == nil {
+		idx.sites = make(map[string]Point)
+		idx.cells = make(map[cell]map[string]bool)
+	}
+	idx.remove(id)
+	c := grid(idx.CellSize).cell(p)
+	if idx.cells[c] == nil {
+		idx.cells[c] = make(map[string]bool)
+	}
+	idx.cells[c][id] = true
+	idx.sites[id] = p
+}
+
+// Remove removes the site with the ID.
+func (idx *SiteIndex) Remove(id string) {
+	idx.mu.Lock()
+	defer idx.mu.Unlock()
+	idx.remove(id)
+}
+
+// remove removes the site with the ID (lock must be held).
+func (idx *SiteIndex) remove(id string) {
+	p, ok := idx.sites[id]
+	if !ok {
+		return
+	}
+	c := grid(idx.CellSize).cell(p)
+	delete(idx.cells[c], id)
+	if len(idx.cells[c]) == 0 {
+		delete(idx.cells, c)
+	}
+	delete(idx.sites, id)
+}
+
+// Hit returns the IDs of the sites covered by any area of the alert.
+func (idx *SiteIndex) Hit(alert *cap.Alert) []string {
+	idx.mu.RLock()
+	defer idx.mu.RUnlock()
+	var ids []string
+	seen := make(map[string]bool)
+	g := grid(idx.CellSize)
+	for i := range alert.Info {
+		for j := range alert.Info[i].Area {
+			shapes, _ := AreaShapes(&alert.Info[i].Area[j])
+			for _, s := range shapes {
+				b := s.Bounds()
+				g.cells(b, func(c cell) {
+					for id := range idx.cells[c] {
+						p := idx.sites[id]
+						if !seen[id] && b.Contains(p) && s.Contains(p) {
+							seen[id] = true
+							ids = append(ids, id)
+						}
+					}
+				})
+			}
+		}
+	}
+	return ids
+}
+
+// Len returns the number of sites.
+func (idx *SiteIndex) Len() int {
+	idx.mu.RLock()
+	defer idx.mu.RUnlock()
+	return len(idx.sites)
+}
+
+// AlertsAt returns the active alerts with an area covering the point, using the
+// client's spatial index of active alerts.
+func (c *Client) AlertsAt(p Point) []*cap.Alert {
+	c.expire()
+	return c.index.Query(p)
+}
+
+// expire removes the expired alerts from the active set and spatial index.
+func (c *Client) expire() {
+	for _, id := range c.active.prune(time.Now()) {
+		c.index.Remove(id)
+	}
+}
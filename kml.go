@@ -0,0 +1,233 @@
+// Copyright (c) 2019 Tanner Ryan. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package naads
+
+import (
+	"encoding/xml"
+	"strconv"
+	"strings"
+
+	"github.com/thetannerryan/cap"
+)
+
+// kmlStyles maps each severity to its placemark style (colors are aabbggrr).
+var kmlStyles = map[cap.Severity]struct {
+	id    string
+	color string
+}{
+	cap.SeverityExtreme:  {"severity-extreme", "b30000ff"},
+	cap.SeveritySevere:   {"severity-severe", "b30080ff"},
+	cap.SeverityModerate: {"severity-moderate", "b300ffff"},
+	cap.SeverityMinor:    {"severity-minor", "b300c000"},
+	cap.SeverityUnknown:  {"severity-unknown", "b3a0a0a0"},
+}
+
+// kmlDocument is the root of a KML 2.2 document.
+type kmlDocument struct {
+	XMLName    xml.Name       `xml:"http://www.opengis.net/kml/2.2 kml"`
+	Name       string         `xml:"Document>name"`
+	Styles     []kmlStyle     `xml:"Document>Style"`
+	Placemarks []kmlPlacemark `xml:"Document>Placemark"`
+}
+
+// kmlStyle is a shared placemark style.
+type kmlStyle struct {
+	ID        string `xml:"id,attr"`
+	LineColor string `xml:"LineStyle>color"`
+	LineWidth int    `xml:"LineStyle>width"`
+	PolyColor string `xml:"PolyStyle>color"`
+}
+
+// kmlPlacemark is a single alert area.
+type kmlPlacemark struct {
+	Name          string            `xml:"name"`
+	Description   string            `xml:"description"`
+	Begin         string            `xml:"TimeSpan>begin,omitempty"`
+	End           string            `xml:"TimeSpan>end,omitempty"`
+	StyleURL      string            `xml:"styleUrl"`
+	ExtendedData  []kmlData         `xml:"ExtendedData>Data"`
+	MultiGeometry *kmlMultiGeometry `xml:"MultiGeometry,omitempty"`
+}
+
+// kmlData is a named value of a placemark's extended data.
+type kmlData struct {
+	Name  string `xml:"name,attr"`
+	Value string `xml:"value"`
+}
+
+// kmlMultiGeometry is the set of polygons of an area.
+type kmlMultiGeometry struct {
+	Polygons []kmlPolygon `xml:"Polygon"`
+}
+
+// kmlPolygon is a polygon with an outer boundary.
+type kmlPolygon struct {
+	Coordinates string `xml:"outerBoundaryIs>LinearRing>coordinates"`
+}
+
+// KML converts the areas of the alerts into a KML document, with one placemark
+// per area. Placemarks are styled by severity, described in every language of
+// the alert, and span from the effective time (or sent time) to the expiry
+// time. Circles are approximated as polygons.
+func KML(alerts ...*cap.Alert) ([]byte, error) {
+	doc := &kmlDocument{Name: "NAADS Alerts"}
+	for _, severity := range []cap.Severity{cap.SeverityExtreme, cap.SeveritySevere, cap.SeverityModerate, cap.SeverityMinor, cap.SeverityUnknown} {
+		style := kmlStyles[severity]
+		doc.Styles = append(doc.Styles, kmlStyle{
+			ID:        style.id,
+			LineColor: "ff" + style.color[2:],
+			LineWidth: 2,
+			PolyColor: style.color,
+		})
+	}
+
+	for _, alert := range alerts {
+		if len(alert.Info) == 0 {
+			continue
+		}
+		// areas are taken from the primary (English) Info block; the other
+		// languages only contribute to the description
+		primary := primaryInfo(alert)
+		for i := range primary.Area {
+			area := &primary.Area[i]
+			placemark := kmlPlacemark{
+				Name:        primary.Headline,
+				Description: kmlDescription(alert, i),
+				StyleURL:    "#" + kmlStyles[maxSeverity(alert)].id,
+				ExtendedData: []kmlData{
+					{Name: "identifier", Value: alert.Identifier},
+					{Name: "event", Value: primary.Event},
+					{Name: "severity", Value: primary.Severity.String()},
+					{Name: "areaDesc", Value: area.AreaDesc},
+				},
+			}
+			if placemark.Name == "" {
+				placemark.Name = primary.Event
+			}
+			if !primary.Effective.Time().IsZero() {
+				placemark.Begin = primary.Effective.String()
+			} else {
+				placemark.Begin = alert.Sent.String()
+			}
+			if !primary.Expires.Time().IsZero() {
+				placemark.End = primary.Expires.String()
+			}
+
+			shapes, _ := AreaShapes(area)
+			if len(shapes) > 0 {
+				placemark.MultiGeometry = &kmlMultiGeometry{}
+				for _, s := range shapes {
+					var poly Polygon
+					switch shape := s.(type) {
+					case Polygon:
+						poly = shape
+					case Circle:
+						poly = shape.Polygon(circleSegments)
+					}
+					placemark.MultiGeometry.Polygons = append(placemark.MultiGeometry.Polygons, kmlPolygon{
+						Coordinates: kmlCoordinates(poly),
+					})
+				}
+			}
+			doc.Placemarks = append(doc.Placemarks, placemark)
+		}
+	}
+
+	data, err := xml.MarshalIndent(doc, "", "  ")
+	if err != nil {
+		return nil, err
+	}
+	return append([]byte(xml.Header), data...), nil
+}
+
+// primaryInfo returns the English Info block of the alert, or the first Info
+// block if there is no English block.
+func primaryInfo(alert *cap.Alert) *cap.Info {
+	for i := range alert.Info {
+		if strings.HasPrefix(strings.ToLower(alert.Info[i].Language), "en") {
+			return &alert.Info[i]
+		}
+	}
+	return &alert.Info[0]
+}
+
+// maxSeverity returns the most severe severity of the Info blocks of the
+// alert.
+func maxSeverity(alert *cap.Alert) cap.Severity {
+	severity := cap.SeverityUnknown
+	for i := range alert.Info {
+		// severity codes are ordered from most severe (Extreme) to least
+		if alert.Info[i].Severity < severity {
+			severity = alert.Info[i].Severity
+		}
+	}
+	return severity
+}
+
+// kmlDescription returns an HTML description of the area with the headline,
+// description and instructions of every Info block of the alert.
+func kmlDescription(alert *cap.Alert, index int) string {
+	area := &primaryInfo(alert).Area[index]
+	var b strings.Builder
+	for i := range alert.Info {
+		info := &alert.Info[i]
+		if i > 0 {
+			b.WriteString("<hr/>")
+		}
+		b.WriteString("<p><b>" + xmlEscape(info.Headline) + "</b> (" + xmlEscape(info.Language) + ")</p>")
+		if len(info.Area) > 0 {
+			// describe the area in the block's own language where possible
+			// (Info blocks of a bilingual alert list the same areas in order)
+			desc := area.AreaDesc
+			if len(info.Area) == len(primaryInfo(alert).Area) && sameGeometry(&info.Area[index], area) {
+				desc = info.Area[index].AreaDesc
+			}
+			b.WriteString("<p><i>" + xmlEscape(desc) + "</i></p>")
+		}
+		if info.Description != "" {
+			b.WriteString("<p>" + xmlEscape(info.Description) + "</p>")
+		}
+		if info.Instruction != "" {
+			b.WriteString("<p>" + xmlEscape(info.Instruction) + "</p>")
+		}
+	}
+	return b.String()
+}
+
+// sameGeometry returns true if the two areas have identical polygons and
+// circles.
+func sameGeometry(a, b *cap.Area) bool {
+	if a.Polygon.String() != b.Polygon.String() || len(a.Circle) != len(b.Circle) {
+		return false
+	}
+	for i := range a.Circle {
+		if a.Circle[i] != b.Circle[i] {
+			return false
+		}
+	}
+	return true
+}
+
+// kmlCoordinates converts the polygon to a closed KML coordinate string.
+func kmlCoordinates(poly Polygon) string {
+	var b strings.Builder
+	for i, pos := range ringPositions(poly) {
+		if i > 0 {
+			b.WriteByte(' ')
+		}
+		b.WriteString(strconv.FormatFloat(pos[0], 'f', -1, 64))
+		b.WriteByte(',')
+		b.WriteString(strconv.FormatFloat(pos[1], 'f', -1, 64))
+		b.WriteString(",0")
+	}
+	return b.String()
+}
+
+// xmlEscape escapes the text for embedding in markup.
+func xmlEscape(val string) string {
+	var b strings.Builder
+	xml.EscapeText(&b, []byte(val))
+	return b.String()
+}
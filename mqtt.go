@@ -0,0 +1,486 @@
+// Copyright (c) 2019 Tanner Ryan. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package naads
+
+import (
+	"bufio"
+	"context"
+	"crypto/tls"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"io"
+	"net"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/thetannerryan/cap"
+)
+
+// EventCodeValueName is the event code value name used by CAP-CP.
+const EventCodeValueName = "profile:CAP-CP:Event:0.4"
+
+const (
+	defaultMQTTPrefix    = "naads"          // default topic prefix
+	defaultMQTTKeepAlive = 60 * time.Second // default keep alive interval
+	mqttMaintenance      = 10 * time.Second // interval of keep alive and expiry checks
+)
+
+// MQTT control packet types (MQTT 3.1.1, fixed header).
+const (
+	mqttConnect    = 0x10
+	mqttConnack    = 0x20
+	mqttPublish    = 0x30
+	mqttPuback     = 0x40
+	mqttPubrec     = 0x50
+	mqttPubrel     = 0x62
+	mqttPubcomp    = 0x70
+	mqttPingreq    = 0xC0
+	mqttPingresp   = 0xD0
+	mqttDisconnect = 0xE0
+)
+
+// MQTT is a sink publishing alerts to an MQTT 3.1.1 broker. Each alert is
+// published to a topic per province of its areas:
+//
+//	<prefix>/<status>/<province>/<event code>/<identifier>
+//
+// for example naads/actual/ON/tornado/urn:oid:2.49.0.1.124.1234. Alerts with
+// no SGC geocodes are published under the province "CA", and heartbeats are
+// published to <prefix>/heartbeat.
+//
+// Alerts in effect are published as retained messages, so new subscribers
+// receive the active alerts. The retained messages of an alert are cleared
+// when it is updated, cancelled or expires (retained messages are tracked in
+// memory, so alerts published before a restart are not cleared).
+//
+// A failed publish closes the connection; the connection is re-established
+// on the next delivery attempt.
+type MQTT struct {
+	Address   string        // Address of the broker (host:port)
+	TLSConfig *tls.Config   // TLS configuration (nil connects without TLS)
+	ClientID  string        // Client identifier (defaults to a random identifier)
+	Username  string        // Username (empty disables authentication)
+	Password  string        // Password
+	Prefix    string        // Topic prefix (defaults to "naads")
+	QoS       byte          // Quality of service of the messages (0, 1 or 2)
+	XML       bool          // Indicator to publish the original XML instead of JSON (cap package JSON tags)
+	KeepAlive time.Duration // Keep alive interval (defaults to 60 seconds)
+
+	mu       sync.Mutex           // Mutex protecting the connection and retained state
+	conn     net.Conn             // Connection to the broker
+	reader   *bufio.Reader        // Reader of the connection
+	packetID uint16               // Identifier of the last QoS 1/2 packet
+	lastSent time.Time            // Time the last packet was sent
+	retained map[string][]string  // Retained topics per alert identifier
+	expires  map[string]time.Time // Expiry of the alerts with retained topics
+	started  bool                 // Indicator that maintenance has started
+}
+
+// Deliver publishes the alert, clearing the retained messages of the alerts
+// it references.
+func (m *MQTT) Deliver(ctx context.Context, msg *Message) error {
+	if m.QoS > 2 {
+		return Permanent(errors.New("Error: invalid MQTT QoS " + strconv.Itoa(int(m.QoS))))
+	}
+	payload := msg.Raw
+	if !m.XML {
+		var err error
+		if payload, err = json.Marshal(msg.Alert); err != nil {
+			return Permanent(err)
+		}
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if err := m.connect(ctx); err != nil {
+		return err
+	}
+	if deadline, ok := ctx.Deadline(); ok {
+		m.conn.SetDeadline(deadline)
+		defer func() {
+			if m.conn != nil {
+				m.conn.SetDeadline(time.Time{})
+			}
+		}()
+	}
+
+	if msg.Class == ClassHeartbeat {
+		return m.publish(m.prefix()+"/heartbeat", payload, false)
+	}
+
+	// updates and cancellations replace the alerts they reference
+	if msg.Alert.MsgType == cap.MsgTypeUpdate || msg.Alert.MsgType == cap.MsgTypeCancel {
+		for _, id := range referencedIdentifiers(msg.Alert) {
+			if err := m.clear(id); err != nil {
+				return err
+			}
+		}
+	}
+
+	retain := msg.Class == ClassAlert && msg.Alert.MsgType != cap.MsgTypeCancel && !expired(msg.Alert, time.Now())
+	topics := m.topics(msg.Alert)
+	for _, topic := range topics {
+		if err := m.publish(topic, payload, retain); err != nil {
+			return err
+		}
+	}
+	if retain {
+		if m.retained == nil {
+			m.retained = make(map[string][]string)
+			m.expires = make(map[string]time.Time)
+		}
+		m.retained[msg.Alert.Identifier] = topics
+		m.expires[msg.Alert.Identifier] = expires(msg.Alert)
+	}
+	return nil
+}
+
+// Close disconnects from the broker.
+func (m *MQTT) Close() error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.conn == nil {
+		return nil
+	}
+	m.conn.Write([]byte{mqttDisconnect, 0})
+	err := m.conn.Close()
+	m.conn = nil
+	return err
+}
+
+// prefix returns the topic prefix.
+func (m *MQTT) prefix() string {
+	if m.Prefix == "" {
+		return defaultMQTTPrefix
+	}
+	return strings.TrimRight(m.Prefix, "/")
+}
+
+// topics returns the topics of the alert (one per province).
+func (m *MQTT) topics(alert *cap.Alert) []string {
+	status := strings.ToLower(alert.Status.String())
+	event := "unknown"
+	if len(alert.Info) > 0 {
+		info := primaryInfo(alert)
+		event = strings.ToLower(info.Event)
+		for _, code := range info.EventCode {
+			if code.ValueName == EventCodeValueName {
+				event = code.Value
+				break
+			}
+		}
+	}
+	provinces := alertProvinces(alert)
+	if len(provinces) == 0 {
+		provinces = []string{"CA"}
+	}
+	var topics []string
+	for _, province := range provinces {
+		topics = append(topics, m.prefix()+"/"+mqttTopicLevel(status)+"/"+province+"/"+mqttTopicLevel(event)+"/"+mqttTopicLevel(alert.Identifier))
+	}
+	return topics
+}
+
+// clear removes the retained messages of the alert.
+func (m *MQTT) clear(identifier string) error {
+	for _, topic := range m.retained[identifier] {
+		// an empty retained message clears the retained message of the topic
+		if err := m.publish(topic, nil, true); err != nil {
+			return err
+		}
+	}
+	delete(m.retained, identifier)
+	delete(m.expires, identifier)
+	return nil
+}
+
+// maintain periodically keeps the connection alive and clears the retained
+// messages of expired alerts.
+func (m *MQTT) maintain() {
+	interval := mqttMaintenance
+	if m.keepAlive()/2 < interval {
+		interval = m.keepAlive() / 2
+	}
+	for {
+		time.Sleep(interval)
+		m.mu.Lock()
+		if m.conn != nil {
+			now := time.Now()
+			m.conn.SetDeadline(now.Add(interval))
+			var err error
+			for id, exp := range m.expires {
+				if !exp.IsZero() && now.After(exp) {
+					if err = m.clear(id); err != nil {
+						break
+					}
+				}
+			}
+			if err == nil && now.Sub(m.lastSent) >= m.keepAlive()/2 {
+				err = m.ping()
+			}
+			if m.conn != nil {
+				m.conn.SetDeadline(time.Time{})
+			}
+		}
+		m.mu.Unlock()
+	}
+}
+
+// keepAlive returns the keep alive interval.
+func (m *MQTT) keepAlive() time.Duration {
+	if m.KeepAlive <= 0 {
+		return defaultMQTTKeepAlive
+	}
+	return m.KeepAlive
+}
+
+// connect establishes the connection to the broker if it is not connected.
+func (m *MQTT) connect(ctx context.Context) error {
+	if m.conn != nil {
+		return nil
+	}
+	dialer := &net.Dialer{}
+	conn, err := dialer.DialContext(ctx, "tcp", m.Address)
+	if err != nil {
+		return err
+	}
+	if m.TLSConfig != nil {
+		config := m.TLSConfig.Clone()
+		if config.ServerName == "" {
+			config.ServerName, _, _ = net.SplitHostPort(m.Address)
+		}
+		conn = tls.Client(conn, config)
+	}
+	if deadline, ok := ctx.Deadline(); ok {
+		conn.SetDeadline(deadline)
+	}
+	m.conn, m.reader = conn, bufio.NewReader(conn)
+
+	clientID := m.ClientID
+	if clientID == "" {
+		clientID = "naads-" + randomID()[:16]
+	}
+	var flags byte = 0x02 // clean session
+	var payload []byte
+	payload = appendMQTTString(payload, clientID)
+	if m.Username != "" {
+		flags |= 0x80
+		payload = appendMQTTString(payload, m.Username)
+		if m.Password != "" {
+			flags |= 0x40
+			payload = appendMQTTString(payload, m.Password)
+		}
+	}
+	keepAlive := int(m.keepAlive() / time.Second)
+	if keepAlive > 65535 {
+		keepAlive = 65535
+	}
+	body := appendMQTTString(nil, "MQTT")
+	body = append(body, 4, flags, byte(keepAlive>>8), byte(keepAlive))
+	body = append(body, payload...)
+	if err := m.send(mqttConnect, body); err != nil {
+		return err
+	}
+	kind, resp, err := m.receive()
+	if err != nil {
+		return err
+	}
+	if kind != mqttConnack || len(resp) != 2 {
+		m.disconnect()
+		return errors.New("Error: unexpected MQTT response to CONNECT")
+	}
+	if resp[1] != 0 {
+		m.disconnect()
+		err := errors.New("Error: MQTT connection refused (code " + strconv.Itoa(int(resp[1])) + ")")
+		if resp[1] == 4 || resp[1] == 5 {
+			// bad credentials or not authorized
+			return Permanent(err)
+		}
+		return err
+	}
+	conn.SetDeadline(time.Time{})
+	if !m.started {
+		m.started = true
+		go m.maintain()
+	}
+	return nil
+}
+
+// publish sends a PUBLISH packet, completing the acknowledgement flow of the
+// QoS.
+func (m *MQTT) publish(topic string, payload []byte, retain bool) error {
+	header := byte(mqttPublish) | m.QoS<<1
+	if retain {
+		header |= 0x01
+	}
+	body := appendMQTTString(nil, topic)
+	var id uint16
+	if m.QoS > 0 {
+		if m.packetID++; m.packetID == 0 {
+			m.packetID = 1
+		}
+		id = m.packetID
+		body = append(body, byte(id>>8), byte(id))
+	}
+	body = append(body, payload...)
+	if err := m.send(header, body); err != nil {
+		return err
+	}
+
+	switch m.QoS {
+	case 1:
+		return m.await(mqttPuback, id)
+	case 2:
+		if err := m.await(mqttPubrec, id); err != nil {
+			return err
+		}
+		if err := m.send(mqttPubrel, []byte{byte(id >> 8), byte(id)}); err != nil {
+			return err
+		}
+		return m.await(mqttPubcomp, id)
+	}
+	return nil
+}
+
+// ping sends a PINGREQ packet and waits for the response.
+func (m *MQTT) ping() error {
+	if err := m.send(mqttPingreq, nil); err != nil {
+		return err
+	}
+	kind, _, err := m.receive()
+	if err != nil {
+		return err
+	}
+	if kind != mqttPingresp {
+		m.disconnect()
+		return errors.New("Error: unexpected MQTT response to PINGREQ")
+	}
+	return nil
+}
+
+// await waits for the acknowledgement of the packet. Other packets (such as
+// late acknowledgements of earlier packets) are ignored.
+func (m *MQTT) await(kind byte, id uint16) error {
+	for {
+		got, body, err := m.receive()
+		if err != nil {
+			return err
+		}
+		if got&0xF0 == kind&0xF0 && len(body) >= 2 && binary.BigEndian.Uint16(body) == id {
+			return nil
+		}
+	}
+}
+
+// send writes a control packet.
+func (m *MQTT) send(header byte, body []byte) error {
+	packet := []byte{header}
+	// remaining length (variable length encoding)
+	length := len(body)
+	for {
+		b := byte(length % 128)
+		length /= 128
+		if length > 0 {
+			b |= 0x80
+		}
+		packet = append(packet, b)
+		if length == 0 {
+			break
+		}
+	}
+	packet = append(packet, body...)
+	if _, err := m.conn.Write(packet); err != nil {
+		m.disconnect()
+		return err
+	}
+	m.lastSent = time.Now()
+	return nil
+}
+
+// receive reads a control packet, returning its type and body.
+func (m *MQTT) receive() (byte, []byte, error) {
+	header, err := m.reader.ReadByte()
+	if err != nil {
+		m.disconnect()
+		return 0, nil, err
+	}
+	length, multiplier := 0, 1
+	for i := 0; ; i++ {
+		b, err := m.reader.ReadByte()
+		if err != nil {
+			m.disconnect()
+			return 0, nil, err
+		}
+		length += int(b&0x7F) * multiplier
+		multiplier *= 128
+		if b&0x80 == 0 {
+			break
+		}
+		if i == 3 {
+			m.disconnect()
+			return 0, nil, errors.New("Error: malformed MQTT remaining length")
+		}
+	}
+	body := make([]byte, length)
+	if _, err := io.ReadFull(m.reader, body); err != nil {
+		m.disconnect()
+		return 0, nil, err
+	}
+	return header, body, nil
+}
+
+// disconnect closes the connection after an error.
+func (m *MQTT) disconnect() {
+	if m.conn != nil {
+		m.conn.Close()
+		m.conn = nil
+	}
+}
+
+// appendMQTTString appends the length prefixed UTF-8 string.
+func appendMQTTString(buf []byte, val string) []byte {
+	buf = append(buf, byte(len(val)>>8), byte(len(val)))
+	return append(buf, val...)
+}
+
+// mqttTopicLevel returns the value as a single topic level, replacing the
+// separator and wildcard characters.
+func mqttTopicLevel(val string) string {
+	val = strings.Map(func(r rune) rune {
+		switch r {
+		case '/', '+', '#', 0:
+			return '_'
+		}
+		return r
+	}, strings.TrimSpace(val))
+	if val == "" {
+		return "_"
+	}
+	return val
+}
+
+// alertProvinces returns the sorted postal abbreviations of the provinces of
+// the SGC geocodes of the alert.
+func alertProvinces(alert *cap.Alert) []string {
+	seen := make(map[string]bool)
+	var provinces []string
+	for _, code := range AlertGeocodes(alert) {
+		if len(code) < 2 {
+			continue
+		}
+		sgc, ok := LookupSGC(code[:2])
+		if !ok || seen[sgc.ProvinceAbbr] {
+			continue
+		}
+		seen[sgc.ProvinceAbbr] = true
+		provinces = append(provinces, sgc.ProvinceAbbr)
+	}
+	sort.Strings(provinces)
+	return provinces
+}
@@ -5,8 +5,11 @@
 package naads
 
 import (
+	"crypto/x509"
+	"errors"
 	"log"
 	"os"
+	"sync"
 	"time"
 
 	"github.com/thetannerryan/cap"
@@ -16,11 +19,35 @@ const version = "v0.0.11" // NAADS client version
 
 // Client represents the configuration for the NAAD client.
 type Client struct {
-	Feeds      []*Feed         // Array of NAADS Feeds to listen to (feeds defined first have greater priority when multiple feeds are available)
-	LogControl bool            // Indicator to log control status to stdout
-	ch         chan *cap.Alert // Alert output channel
-	activeFeed int             // Index of active feed
-	startTime  time.Time       // Start time of client
+	Feeds         []*Feed           // Array of NAADS Feeds to listen to (feeds defined first have greater priority when multiple feeds are available)
+	LogControl    bool              // Indicator to log control status to stdout
+	Rules         []ClassRule       // Classification rules evaluated before the default classification
+	Routes        map[Class]Route   // Forwarding policy per message class (classes not present use the default routes)
+	Signatures    SignaturePolicy   // Action taken on the XML digital signature of messages
+	Trust         *TrustStore       // Trust store validating signing certificates (REQUIRED unless Signatures is SignaturePass; without it every signature is invalid)
+	HistorySize   int               // Number of recent messages retained for lookup (defaults to 1024)
+	HistoryBytes  int               // Total size of the XML and resource data of the messages retained for lookup (defaults to 64 MB)
+	HTTPConfig    HTTPConfig        // Bind address, TLS and authentication of the HTTP status endpoint
+	ResourceDir   string            // Directory to save decoded embedded resources to (empty retains them in memory)
+	StripDerefURI bool              // Indicator to remove the embedded data of decoded resources from delivered alerts (Raw still holds it, except in the history when ResourceDir is set)
+	Fetcher       *ResourceFetcher  // Fetcher downloading resources referenced by URI on receipt, off the delivery path (nil disables fetching)
+	Outputs       []*Output         // Sinks receiving forwarded messages (each delivered by its own worker)
+	Relay         *Relay            // TCP server re-streaming the raw messages of the locked feed (nil disables relaying)
+	DropAlerts    bool              // Indicator to drop alerts while the output channel is full, rather than waiting for it to be read
+	ch            chan *cap.Alert   // Alert output channel
+	subscribers   []*subscriber     // Filtered alert output channels
+	fetches       chan *fetchJob    // Messages waiting for referenced resources to be downloaded
+	lastJob       *fetchJob         // Most recent message completed in order of arrival
+	mu            sync.Mutex        // Mutex protecting subscribers, the dropped counts and lastJob
+	active        activeSet         // Alerts currently in effect
+	index         Index             // Spatial index of the active alerts
+	history       history           // Recently received messages
+	countValid    int               // Count of messages with a valid signature
+	countInvalid  int               // Count of messages with an invalid signature
+	countDropped  int               // Count of alerts dropped because the output channel was full
+	signer        *x509.Certificate // Most recent valid signing certificate
+	activeFeed    int               // Index of active feed
+	startTime     time.Time         // Start time of client
 }
 
 // Start will start the highly available NAADS client. It will connect to all
@@ -29,6 +56,10 @@ type Client struct {
 // the client will automatically lock onto another available feed. The
 // individual feeds are responsible for providing their connection status, and
 // for performing reconnect procedures.
+//
+// The output channel must be read: the client waits while it is full, unless
+// DropAlerts is set (for applications using only subscribers or outputs), in
+// which case alerts are dropped and counted on the status page.
 func (c *Client) Start() chan *cap.Alert {
 	// log.Printf to stdout
 	log.SetOutput(os.Stdout)
@@ -38,16 +69,45 @@ func (c *Client) Start() chan *cap.Alert {
 	c.ch = make(chan *cap.Alert, 16)
 	// update start time
 	c.startTime = time.Now()
+	// load the trust store
+	if c.Trust != nil {
+		if err := c.Trust.Load(); err != nil && c.LogControl {
+			log.Printf("CONTROL [ERROR]  Unable to load trust store: %s\n", err)
+		}
+	} else if c.Signatures != SignaturePass && c.LogControl {
+		log.Printf("CONTROL [ERROR]  No trust store configured; every signature will be treated as invalid\n")
+	}
+
+	// start the output delivery workers
+	for _, o := range c.Outputs {
+		o.start()
+	}
+
+	// start the resource download workers
+	if c.Fetcher != nil {
+		c.startFetching()
+	}
+
+	// start the relay
+	if c.Relay != nil {
+		if err := c.Relay.start(); err != nil && c.LogControl {
+			log.Printf("CONTROL [ERROR]  Unable to start relay: %s\n", err)
+		}
+	}
 
 	// start each feed in a goroutine (feed has it's own subclient)
 	for index, feed := range c.Feeds {
+		feed.rules = c.Rules
 		go func(i int, f *Feed) {
 			// range over single feed's output channel
-			for alert := range f.start() {
+			for msg := range f.start() {
 				// forward message to output channel only if the feed is locked
 				// as the active feed
 				if i == c.activeFeed {
-					c.ch <- alert
+					if c.Relay != nil {
+						c.Relay.broadcast(msg.Raw)
+					}
+					c.forward(f, msg)
 				}
 			}
 		}(index, feed)
@@ -58,28 +118,151 @@ func (c *Client) Start() chan *cap.Alert {
 	return c.ch
 }
 
+// forward verifies the signature of the message (according to the signature
+// policy) and applies the forwarding policy of the message class, passing the
+// alert to the output channel if it is to be forwarded.
+func (c *Client) forward(f *Feed, msg *Message) {
+	// verify the XML digital signature
+	if c.Signatures != SignaturePass {
+		// the certificate is carried by the message itself, so a signature
+		// only authenticates the message once its certificate is trusted
+		msg.Signature = VerifySignature(msg.Raw)
+		if msg.Signature.Valid {
+			var err error
+			if c.Trust == nil {
+				err = errors.New("Error: no trust store to validate the signing certificate")
+			} else {
+				err = c.Trust.Verify(msg.Signature.Certificate, msg.Signature.Intermediates, time.Now())
+			}
+			if err != nil {
+				msg.Signature.Valid = false
+				msg.Signature.Err = err
+			}
+		}
+		if msg.Signature.Valid {
+			c.countValid++
+			c.updateSigner(msg.Signature.Certificate)
+		} else {
+			c.countInvalid++
+			if c.LogControl {
+				log.Printf("CONTROL [ERROR]  Invalid signature on %s %s (%s)\n", msg.Class, msg.Alert.Identifier, msg.Signature.Err)
+			}
+			if c.Signatures == SignatureReject {
+				return
+			}
+		}
+	}
+
+	// decode the embedded resources
+	msg.Resources = c.extractResources(msg.Alert)
+	c.logResources(msg, false)
+
+	// track the alerts currently in effect
+	if msg.Class == ClassAlert {
+		for _, id := range c.active.update(msg.Alert) {
+			c.index.Remove(id)
+		}
+		if _, ok := c.active.get(msg.Alert.Identifier); ok {
+			c.index.Add(msg.Alert)
+		}
+	}
+
+	// heartbeats are only forwarded if requested by the feed
+	deliver := false
+	if msg.Class != ClassHeartbeat || f.SendHeartbeat {
+		switch c.route(msg.Class) {
+		case RouteForward:
+			if c.DropAlerts {
+				select {
+				case c.ch <- msg.Alert:
+				default:
+					c.mu.Lock()
+					c.countDropped++
+					dropped := c.countDropped
+					c.mu.Unlock()
+					if c.LogControl {
+						log.Printf("CONTROL [ERROR]  Output channel is full; dropped %s (%d dropped)\n", msg.Alert.Identifier, dropped)
+					}
+				}
+			} else {
+				c.ch <- msg.Alert
+			}
+			c.publish(msg.Alert)
+			deliver = true
+		case RouteLog:
+			if c.LogControl {
+				log.Printf("CONTROL [STATUS] Dropped %s %s\n", msg.Class, msg.Alert.Identifier)
+			}
+		}
+	}
+
+	// with a fetcher, messages are completed in order of arrival once their
+	// resources referenced by URI are downloaded
+	if c.Fetcher != nil {
+		c.completeInOrder(msg, deliver)
+		return
+	}
+	c.complete(msg, deliver)
+}
+
+// complete retains the message for lookup and, if it is to be delivered,
+// queues it to the outputs.
+func (c *Client) complete(msg *Message, deliver bool) {
+	// retain the message for lookup (heartbeats would flush the history)
+	if msg.Class != ClassHeartbeat {
+		retained := msg
+		if c.ResourceDir != "" {
+			retained = stripSaved(msg)
+		}
+		c.history.add(retained, c.HistorySize, c.HistoryBytes)
+	}
+	if deliver {
+		for _, o := range c.Outputs {
+			o.enqueue(msg)
+		}
+	}
+}
+
+// updateSigner records the most recent valid signing certificate, logging a
+// warning when a certificate approaching expiry is first seen.
+func (c *Client) updateSigner(cert *x509.Certificate) {
+	if c.signer != nil && c.signer.Equal(cert) {
+		return
+	}
+	c.signer = cert
+	if c.Trust != nil && c.Trust.expiring(cert, time.Now()) && c.LogControl {
+		log.Printf("CONTROL [ERROR]  Signing certificate %s expires %s\n", cert.Subject.CommonName, cert.NotAfter.Format(time.RFC3339))
+	}
+}
+
 // monitor is responsible for continuously monitoring the health of the feeds.
 // If the current locked feed is down, or if there are no available feeds, it
 // will continue searching for feeds until a feed is available (and locked).
 func (c *Client) monitor() {
 	go func() {
+		connected := make([]bool, len(c.Feeds))
+		allDown := false
 		for {
 			// initial delay + check health every second
 			time.Sleep(1 * time.Second)
+			// remove expired alerts from the active set
+			c.expire()
 
-			if c.activeFeed == -1 {
-				// currently not locked to feed
-				feedIndex := c.findAvailableFeed()
-				if feedIndex == -1 && c.LogControl {
-					log.Printf("CONTROL [ERROR]  ALL FEEDS ARE DEAD !!\n")
-				} else {
-					// lock the new feed
-					c.activeFeed = feedIndex
-					currentFeed := c.Feeds[c.activeFeed]
-					if c.LogControl {
-						log.Printf("CONTROL [STATUS] Successfully locked feed to %s\n", currentFeed.Name)
+			// report feed connection changes
+			for i, f := range c.Feeds {
+				if f.isConnected != connected[i] {
+					connected[i] = f.isConnected
+					if f.isConnected {
+						c.emit(EventFeedUp, f.Name, "Connected to "+f.Host)
+					} else {
+						c.emit(EventFeedDown, f.Name, "Disconnected from "+f.Host)
 					}
 				}
+			}
+
+			if c.activeFeed == -1 {
+				// currently not locked to feed
+				c.lockAvailableFeed(&allDown)
 			} else {
 				// currently locked to feed, check health and switch if
 				// necessary
@@ -87,23 +270,46 @@ func (c *Client) monitor() {
 				if !currentFeed.isConnected {
 					// current feed is down, find another feed
 					c.activeFeed = -1
-					feedIndex := c.findAvailableFeed()
-					if feedIndex == -1 && c.LogControl {
-						log.Printf("CONTROL [ERROR]  ALL FEEDS ARE DEAD !!\n")
-					} else {
-						// lock the new feed
-						c.activeFeed = feedIndex
-						currentFeed := c.Feeds[c.activeFeed]
-						if c.LogControl {
-							log.Printf("CONTROL [STATUS] Successfully locked feed to %s\n", currentFeed.Name)
-						}
-					}
+					c.lockAvailableFeed(&allDown)
 				}
 			}
 		}
 	}()
 }
 
+// lockAvailableFeed locks the first available feed. If no feeds are
+// available, the client remains unlocked (allDown records that the outage has
+// been reported to the outputs).
+func (c *Client) lockAvailableFeed(allDown *bool) {
+	feedIndex := c.findAvailableFeed()
+	if feedIndex == -1 {
+		if c.LogControl {
+			log.Printf("CONTROL [ERROR]  ALL FEEDS ARE DEAD !!\n")
+		}
+		if !*allDown {
+			*allDown = true
+			c.emit(EventAllDown, "", "All feeds are dead")
+		}
+		return
+	}
+	// lock the new feed
+	*allDown = false
+	c.activeFeed = feedIndex
+	currentFeed := c.Feeds[c.activeFeed]
+	if c.LogControl {
+		log.Printf("CONTROL [STATUS] Successfully locked feed to %s\n", currentFeed.Name)
+	}
+	c.emit(EventLocked, currentFeed.Name, "Locked feed to "+currentFeed.Name)
+}
+
+// emit passes a control event to the outputs.
+func (c *Client) emit(kind EventKind, feed, message string) {
+	ev := &Event{Kind: kind, Feed: feed, Time: time.Now(), Message: message}
+	for _, o := range c.Outputs {
+		o.enqueueEvent(ev)
+	}
+}
+
 // findAvailableFeed returns the index of the first feed in Feeds that is
 // connected. If there are no feeds that are connected, -1 is returned.
 func (c *Client) findAvailableFeed() int {
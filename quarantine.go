@@ -0,0 +1,85 @@
+// Copyright (c) 2019 Tanner Ryan. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package naads
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync/atomic"
+	"time"
+)
+
+const defaultQuarantineMax = 1000 // default maximum number of quarantined messages
+
+var quarantineSeq uint64 // sequence number distinguishing quarantined messages
+
+// quarantine saves the rejected message to the directory for later
+// inspection. The raw data is written to a .xml file, alongside a .txt file
+// recording the feed, time and error. The oldest messages are removed once the
+// directory holds more than max messages (defaults to 1000).
+func quarantine(dir string, max int, feed string, data []byte, reason error) error {
+	if err := os.MkdirAll(dir, 0750); err != nil {
+		return err
+	}
+	now := time.Now().UTC()
+	name := fmt.Sprintf("%s-%s-%d", safeFilename(feed), now.Format("20060102T150405.000Z"), atomic.AddUint64(&quarantineSeq, 1))
+	if err := ioutil.WriteFile(filepath.Join(dir, name+".xml"), data, 0640); err != nil {
+		return err
+	}
+	info := fmt.Sprintf("Feed: %s\nTime: %s\nSize: %d bytes\nReason: %s\n", feed, now.Format(time.RFC3339Nano), len(data), reason)
+	if err := ioutil.WriteFile(filepath.Join(dir, name+".txt"), []byte(info), 0640); err != nil {
+		return err
+	}
+	if max <= 0 {
+		max = defaultQuarantineMax
+	}
+	return pruneQuarantine(dir, max)
+}
+
+// pruneQuarantine removes the oldest messages (and their .txt files) of the
+// directory, keeping at most max messages.
+func pruneQuarantine(dir string, max int) error {
+	files, err := ioutil.ReadDir(dir)
+	if err != nil {
+		return err
+	}
+	var messages []os.FileInfo
+	for _, file := range files {
+		if !file.IsDir() && strings.HasSuffix(file.Name(), ".xml") {
+			messages = append(messages, file)
+		}
+	}
+	if len(messages) <= max {
+		return nil
+	}
+	sort.Slice(messages, func(i, j int) bool {
+		return messages[i].ModTime().Before(messages[j].ModTime())
+	})
+	for _, file := range messages[:len(messages)-max] {
+		base := filepath.Join(dir, strings.TrimSuffix(file.Name(), ".xml"))
+		if err := os.Remove(base + ".xml"); err != nil && !os.IsNotExist(err) {
+			return err
+		}
+		if err := os.Remove(base + ".txt"); err != nil && !os.IsNotExist(err) {
+			return err
+		}
+	}
+	return nil
+}
+
+// safeFilename replaces the characters of the name that are unsafe in file
+// names.
+func safeFilename(name string) string {
+	return strings.Map(func(r rune) rune {
+		if (r >= 'a' && r <= 'z') || (r >= 'A' && r <= 'Z') || (r >= '0' && r <= '9') || r == '-' || r == '_' || r == '.' {
+			return r
+		}
+		return '_'
+	}, name)
+}
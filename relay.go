@@ -0,0 +1,173 @@
+// Copyright (c) 2019 Tanner Ryan. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package naads
+
+import (
+	"io"
+	"io/ioutil"
+	"log"
+	"net"
+	"sync"
+	"time"
+)
+
+const (
+	defaultRelayAddress   = ":8080"          // default listen address (the NAADS port)
+	defaultRelayQueueSize = 64               // default number of messages queued per connection
+	relayWriteTimeout     = 30 * time.Second // timeout writing a message to a connection
+)
+
+// Relay is a TCP server re-streaming the raw messages (alerts and heartbeats)
+// received from the locked feed in the NAADS protocol. Each message is
+// written as framed by the feed (the Raw bytes of the Message): the document
+// from its XML declaration to its closing alert tag, unmodified. Bytes
+// received between documents are not relayed. Downstream Feed instances and
+// other NAADS consumers may connect to the relay instead of the NAADS
+// servers.
+//
+// Messages are relayed before signature verification and routing, so
+// downstream consumers apply their own policies; messages rejected by the
+// feed (such as malformed documents) are not relayed. A connection that falls
+// QueueSize messages behind is closed, so a slow consumer cannot delay the
+// client.
+type Relay struct {
+	Address    string                   // Address to listen on (defaults to ":8080")
+	MaxClients int                      // Maximum number of connections (0 is unlimited)
+	QueueSize  int                      // Number of messages queued per connection (defaults to 64)
+	LogStatus  bool                     // Indicator to log connections to stdout
+	listener   net.Listener             // Listener accepting connections
+	mu         sync.Mutex               // Mutex protecting the connections
+	clients    map[net.Conn]chan []byte // Message queue of each connection
+}
+
+// start listens on the relay address and accepts connections.
+func (r *Relay) start() error {
+	address := r.Address
+	if address == "" {
+		address = defaultRelayAddress
+	}
+	listener, err := net.Listen("tcp", address)
+	if err != nil {
+		return err
+	}
+	r.listener = listener
+	r.clients = make(map[net.Conn]chan []byte)
+	if r.LogStatus {
+		log.Printf("RELAY [STATUS] Listening on %s\n", listener.Addr())
+	}
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				if ne, ok := err.(net.Error); ok && ne.Temporary() {
+					time.Sleep(100 * time.Millisecond)
+					continue
+				}
+				if r.LogStatus {
+					log.Printf("RELAY [ERROR]  Stopped accepting connections: %s\n", err)
+				}
+				return
+			}
+			r.accept(conn)
+		}
+	}()
+	return nil
+}
+
+// Close stops the relay, closing the listener and every connection.
+func (r *Relay) Close() error {
+	if r.listener == nil {
+		return nil
+	}
+	err := r.listener.Close()
+	r.mu.Lock()
+	for conn := range r.clients {
+		conn.Close()
+	}
+	r.mu.Unlock()
+	return err
+}
+
+// Clients returns the number of connections to the relay.
+func (r *Relay) Clients() int {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return len(r.clients)
+}
+
+// accept registers the connection and starts streaming to it.
+func (r *Relay) accept(conn net.Conn) {
+	size := r.QueueSize
+	if size <= 0 {
+		size = defaultRelayQueueSize
+	}
+	r.mu.Lock()
+	if r.MaxClients > 0 && len(r.clients) >= r.MaxClients {
+		r.mu.Unlock()
+		if r.LogStatus {
+			log.Printf("RELAY [ERROR]  Refused connection from %s (maximum of %d connections)\n", conn.RemoteAddr(), r.MaxClients)
+		}
+		conn.Close()
+		return
+	}
+	ch := make(chan []byte, size)
+	r.clients[conn] = ch
+	r.mu.Unlock()
+	if r.LogStatus {
+		log.Printf("RELAY [STATUS] Accepted connection from %s\n", conn.RemoteAddr())
+	}
+
+	// consumers never send data; reading detects when they disconnect
+	go func() {
+		io.Copy(ioutil.Discard, conn)
+		r.remove(conn)
+	}()
+	go func() {
+		for data := range ch {
+			conn.SetWriteDeadline(time.Now().Add(relayWriteTimeout))
+			if _, err := conn.Write(data); err != nil {
+				r.remove(conn)
+				return
+			}
+		}
+	}()
+}
+
+// remove closes the connection and its message queue, if still registered.
+func (r *Relay) remove(conn net.Conn) {
+	r.mu.Lock()
+	ch, ok := r.clients[conn]
+	delete(r.clients, conn)
+	r.mu.Unlock()
+	if !ok {
+		return
+	}
+	close(ch)
+	conn.Close()
+	if r.LogStatus {
+		log.Printf("RELAY [STATUS] Closed connection from %s\n", conn.RemoteAddr())
+	}
+}
+
+// broadcast queues the raw message to every connection. Connections whose
+// queue is full are closed.
+func (r *Relay) broadcast(data []byte) {
+	var slow []net.Conn
+	r.mu.Lock()
+	for conn, ch := range r.clients {
+		select {
+		case ch <- data:
+		default:
+			slow = append(slow, conn)
+		}
+	}
+	r.mu.Unlock()
+	for _, conn := range slow {
+		if r.LogStatus {
+			log.Printf("RELAY [ERROR]  Connection from %s is too slow; disconnecting\n", conn.RemoteAddr())
+		}
+		r.remove(conn)
+	}
+}
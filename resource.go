@@ -0,0 +1,291 @@
+// Copyright (c) 2019 Tanner Ryan. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package naads
+
+import (
+	"bytes"
+	"crypto/sha1"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"io/ioutil"
+	"log"
+	"mime"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"github.com/thetannerryan/cap"
+)
+
+// Resource is a resource of a received alert, decoded from the base64 data of
+// the CAP resource's DerefURI or fetched from its URI.
+type Resource struct {
+	Info     int        // Index of the info block containing the resource
+	Index    int        // Index of the resource within the info block
+	Desc     string     // Description of the resource
+	MimeType string     // Declared MIME type of the resource
+	URI      string     // Declared URI of the resource
+	Data     []byte     // Decoded content (nil when saved to Path)
+	Size     int        // Size of the decoded content in bytes
+	Digest   string     // SHA-1 digest of the decoded content (hex)
+	Sniffed  string     // MIME type detected from the content
+	Path     string     // Local file containing the content (empty if not saved)
+	Remote   bool       // Indicator that the content was fetched from URI
+	Mismatch bool       // Indicator that the declared MIME type does not match the content
+	Audio    *AudioInfo // Format and duration of audio content (nil if not audio)
+	Err      error      // Error encountered decoding, verifying or inspecting the resource
+}
+
+// DecodeResource decodes the base64 data of the CAP resource's DerefURI,
+// verifying the declared size and SHA-1 digest when present.
+func DecodeResource(res *cap.Resource) ([]byte, error) {
+	if res.DerefURI == "" {
+		return nil, errors.New("Error: resource has no embedded data")
+	}
+	// the data may be wrapped across lines
+	encoded := strings.Map(func(r rune) rune {
+		if r == ' ' || r == '\t' || r == '\r' || r == '\n' {
+			return -1
+		}
+		return r
+	}, res.DerefURI)
+	data, err := base64.StdEncoding.DecodeString(encoded)
+	if err != nil {
+		return nil, errors.New("Error: cannot decode resource data: " + err.Error())
+	}
+	if err := VerifyResource(res, data); err != nil {
+		return nil, err
+	}
+	return data, nil
+}
+
+// VerifyResource verifies the content of the resource against its declared
+// size and SHA-1 digest. Fields that are not declared are not verified.
+func VerifyResource(res *cap.Resource, data []byte) error {
+	if res.Size > 0 && res.Size != len(data) {
+		return errors.New("Error: resource size " + strconv.Itoa(len(data)) + " does not match declared size " + strconv.Itoa(res.Size))
+	}
+	if digest := strings.TrimSpace(res.Digest); digest != "" {
+		if sum := sha1Hex(data); !strings.EqualFold(sum, digest) {
+			return errors.New("Error: resource digest " + sum + " does not match declared digest " + digest)
+		}
+	}
+	return nil
+}
+
+// SaveResource writes the content to dir, naming the file by its SHA-1 digest
+// with an extension for the MIME type (or the detected type, if the MIME type
+// is not recognized). Existing files are not rewritten, so
+// resources repeated across alerts are stored once. The path of the file is
+// returned.
+func SaveResource(data []byte, mimeType, dir string) (string, error) {
+	ext := resourceExt(mimeType)
+	if ext == ".bin" {
+		ext = resourceExt(SniffMIME(data))
+	}
+	path := filepath.Join(dir, sha1Hex(data)+ext)
+	if _, err := os.Stat(path); err == nil {
+		return path, nil
+	}
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return "", err
+	}
+	// write to a temporary file, so partial files are never visible
+	tmp, err := ioutil.TempFile(dir, ".resource-")
+	if err != nil {
+		return "", err
+	}
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		os.Remove(tmp.Name())
+		return "", err
+	}
+	if err := tmp.Close(); err != nil {
+		os.Remove(tmp.Name())
+		return "", err
+	}
+	if err := os.Chmod(tmp.Name(), 0644); err != nil {
+		os.Remove(tmp.Name())
+		return "", err
+	}
+	if err := os.Rename(tmp.Name(), path); err != nil {
+		os.Remove(tmp.Name())
+		return "", err
+	}
+	return path, nil
+}
+
+// SniffMIME returns the MIME type of the content, recognizing the audio
+// formats used by NAADS in addition to those of http.DetectContentType.
+func SniffMIME(data []byte) string {
+	switch {
+	case len(data) >= 12 && bytes.HasPrefix(data, []byte("RIFF")) && bytes.Equal(data[8:12], []byte("WAVE")):
+		return "audio/wav"
+	case bytes.HasPrefix(data, []byte("ID3")):
+		return "audio/mpeg"
+	case len(data) >= 2 && data[0] == 0xFF && data[1]&0xE0 == 0xE0 && data[1]&0x06 != 0:
+		// MPEG audio frame sync (layer I, II or III)
+		return "audio/mpeg"
+	}
+	ct := http.DetectContentType(data)
+	if i := strings.IndexByte(ct, ';'); i >= 0 {
+		ct = ct[:i]
+	}
+	return ct
+}
+
+// sameMIME returns true if the MIME types are equivalent, accounting for the
+// aliases in common use for audio (including the IPAWS types used by NAADS).
+func sameMIME(a, b string) bool {
+	canonical := func(t string) string {
+		t = strings.ToLower(strings.TrimSpace(t))
+		if i := strings.IndexByte(t, ';'); i >= 0 {
+			t = strings.TrimSpace(t[:i])
+		}
+		switch t {
+		case "audio/wave", "audio/x-wav", "audio/vnd.wave", "audio/x-pn-wav", "audio/x-ipaws-audio-wav":
+			return "audio/wav"
+		case "audio/mp3", "audio/x-mp3", "audio/mpeg3", "audio/x-mpeg", "audio/mpg", "audio/x-ipaws-audio-mp3":
+			return "audio/mpeg"
+		case "image/jpg", "image/pjpeg":
+			return "image/jpeg"
+		}
+		return t
+	}
+	return canonical(a) == canonical(b)
+}
+
+// mimeMismatch returns true if the declared MIME type contradicts the type
+// detected from the content. Content that cannot be identified never
+// mismatches.
+func mimeMismatch(declared, sniffed string) bool {
+	if sniffed == "application/octet-stream" || strings.TrimSpace(declared) == "" {
+		return false
+	}
+	if media := strings.ToLower(strings.TrimSpace(declared)); sniffed == "text/plain" && !strings.HasPrefix(media, "audio/") && !strings.HasPrefix(media, "image/") {
+		// text sniffing cannot distinguish text formats
+		return false
+	}
+	return !sameMIME(declared, sniffed)
+}
+
+// resourceExt returns the file extension for the MIME type.
+func resourceExt(mimeType string) string {
+	switch {
+	case sameMIME(mimeType, "audio/wav"):
+		return ".wav"
+	case sameMIME(mimeType, "audio/mpeg"):
+		return ".mp3"
+	case sameMIME(mimeType, "image/jpeg"):
+		return ".jpg"
+	}
+	if exts, err := mime.ExtensionsByType(mimeType); err == nil && len(exts) > 0 {
+		return exts[0]
+	}
+	return ".bin"
+}
+
+// sha1Hex returns the hex encoded SHA-1 digest of the data.
+func sha1Hex(data []byte) string {
+	sum := sha1.Sum(data)
+	return hex.EncodeToString(sum[:])
+}
+
+// extractResources decodes the embedded resources of the alert. Resources are
+// saved to ResourceDir when configured, and the DerefURI of resources decoded
+// successfully is removed from the alert if StripDerefURI is set. Stripping
+// only shortens the delivered alert: the decoded data is held by the Resource,
+// and the embedded data by the XML retained in the message history, unless it
+// is saved to ResourceDir. Resources without embedded data are returned unfetched (with
+// Remote set) if a Fetcher is configured, to be downloaded by the fetch workers.
+func (c *Client) extractResources(alert *cap.Alert) []*Resource {
+	var resources []*Resource
+	for i := range alert.Info {
+		for j := range alert.Info[i].Resource {
+			res := &alert.Info[i].Resource[j]
+			r := &Resource{
+				Info:     i,
+				Index:    j,
+				Desc:     res.ResourceDesc,
+				MimeType: res.MimeType,
+				URI:      res.URI,
+			}
+			if res.DerefURI == "" {
+				if c.Fetcher == nil || strings.TrimSpace(res.URI) == "" {
+					continue
+				}
+				r.Remote = true
+				resources = append(resources, r)
+				continue
+			}
+			r.Data, r.Err = DecodeResource(res)
+			if r.Err == nil {
+				r.Size = len(r.Data)
+				r.Digest = sha1Hex(r.Data)
+				r.Sniffed = SniffMIME(r.Data)
+				r.Mismatch = mimeMismatch(r.MimeType, r.Sniffed)
+				if strings.HasPrefix(r.Sniffed, "audio/") {
+					r.Audio, r.Err = InspectAudio(r.Data)
+				}
+				if c.ResourceDir != "" {
+					if path, err := SaveResource(r.Data, r.MimeType, c.ResourceDir); err != nil {
+						r.Err = err
+					} else {
+						r.Path, r.Data = path, nil
+					}
+				}
+				if c.StripDerefURI {
+					res.DerefURI = ""
+				}
+			}
+			resources = append(resources, r)
+		}
+	}
+	return resources
+}
+
+// fetchResources downloads the resources of the alert referenced by URI.
+func (c *Client) fetchResources(alert *cap.Alert, resources []*Resource) {
+	for _, r := range resources {
+		if !r.Remote {
+			continue
+		}
+		res := &alert.Info[r.Info].Resource[r.Index]
+		if r.Path, r.Err = c.Fetcher.Fetch(res); r.Err == nil {
+			r.Err = describeFile(r)
+		}
+		if r.Err == nil {
+			r.Mismatch = mimeMismatch(r.MimeType, r.Sniffed)
+			if strings.HasPrefix(r.Sniffed, "audio/") {
+				var data []byte
+				if data, r.Err = ioutil.ReadFile(r.Path); r.Err == nil {
+					r.Audio, r.Err = InspectAudio(data)
+				}
+			}
+		}
+	}
+}
+
+// logResources logs the errors and MIME type mismatches of the decoded
+// (remote false) or fetched (remote true) resources of the message.
+func (c *Client) logResources(msg *Message, remote bool) {
+	if !c.LogControl {
+		return
+	}
+	for _, r := range msg.Resources {
+		if r.Remote != remote {
+			continue
+		}
+		if r.Err != nil {
+			log.Printf("CONTROL [ERROR]  Resource %q of %s: %s\n", r.Desc, msg.Alert.Identifier, r.Err)
+		}
+		if r.Mismatch {
+			log.Printf("CONTROL [ERROR]  Resource %q of %s declared as %s contains %s\n", r.Desc, msg.Alert.Identifier, r.MimeType, r.Sniffed)
+		}
+	}
+}
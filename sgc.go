@@ -0,0 +1,165 @@
+// Copyright (c) 2019 Tanner Ryan. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package naads
+
+import (
+	"errors"
+	"strconv"
+	"strings"
+
+	"github.com/thetannerryan/cap"
+)
+
+// SGCValueName is the geocode value name used by CAP-CP for Standard
+// Geographical Classification (SGC) location codes.
+const SGCValueName = "profile:CAP-CP:Location:0.3"
+
+// SGCLevel is the level of a Standard Geographical Classification code within
+// the province / census division / census subdivision hierarchy.
+type SGCLevel int
+
+const (
+	// SGCProvince :: Two digit province or territory code
+	SGCProvince SGCLevel = 0
+	// SGCDivision :: Four digit census division code
+	SGCDivision SGCLevel = 1
+	// SGCSubdivision :: Seven digit census subdivision code
+	SGCSubdivision SGCLevel = 2
+)
+
+// SGC describes a Standard Geographical Classification code.
+type SGC struct {
+	Code         string   // SGC code
+	Level        SGCLevel // Level of the code in the hierarchy
+	Name         string   // Name of the province or census division (always empty for census subdivisions)
+	Province     string   // Name of the province or territory containing the area
+	ProvinceAbbr string   // Postal abbreviation of the province or territory
+}
+
+// LookupSGC returns the description of the SGC code using the embedded offline
+// table, which names provinces and census divisions only. Census subdivisions
+// are resolved to their province, with an empty Name. False is returned for
+// malformed codes, unknown provinces and unknown census divisions.
+func LookupSGC(code string) (SGC, bool) {
+	code = strings.TrimSpace(code)
+	level, ok := sgcLevel(code)
+	if !ok {
+		return SGC{}, false
+	}
+	province, ok := sgcProvinces[code[:2]]
+	if !ok {
+		return SGC{}, false
+	}
+
+	sgc := SGC{
+		Code:         code,
+		Level:        level,
+		Province:     province.name,
+		ProvinceAbbr: province.abbr,
+	}
+	switch level {
+	case SGCProvince:
+		sgc.Name = province.name
+	case SGCDivision:
+		name, ok := sgcDivisions[code]
+		if !ok {
+			return SGC{}, false
+		}
+		sgc.Name = name
+	}
+	return sgc, true
+}
+
+// sgcLevel returns the level of the SGC code, or false if the code is not made
+// of 2, 4 or 7 digits.
+func sgcLevel(code string) (SGCLevel, bool) {
+	var level SGCLevel
+	switch len(code) {
+	case 2:
+		level = SGCProvince
+	case 4:
+		level = SGCDivision
+	case 7:
+		level = SGCSubdivision
+	default:
+		return 0, false
+	}
+	for _, r := range code {
+		if r < '0' || r > '9' {
+			return 0, false
+		}
+	}
+	return level, true
+}
+
+// ProvinceCode returns the two digit SGC code of the province or territory
+// with the postal abbreviation (e.g. "ON" returns "35").
+func ProvinceCode(abbr string) (string, bool) {
+	abbr = strings.ToUpper(strings.TrimSpace(abbr))
+	for code, province := range sgcProvinces {
+		if province.abbr == abbr {
+			return code, true
+		}
+	}
+	return "", false
+}
+
+// AlertGeocodes returns the SGC codes of every area of the alert.
+func AlertGeocodes(alert *cap.Alert) []string {
+	var codes []string
+	for i := range alert.Info {
+		for j := range alert.Info[i].Area {
+			for _, geocode := range alert.Info[i].Area[j].Geocode {
+				if geocode.ValueName == SGCValueName {
+					codes = append(codes, strings.TrimSpace(geocode.Value))
+				}
+			}
+		}
+	}
+	return codes
+}
+
+// GeocodeFilter matches alerts with an SGC geocode related to any of its codes.
+// Codes are matched hierarchically: a province code ("35") matches alerts for
+// any division or subdivision within the province, a census division code
+// ("3506") matches alerts for its subdivisions, and any code matches alerts
+// issued for an enclosing division or province. Codes that are not made of 2,
+// 4 or 7 digits (in the filter or the alert) match nothing; use
+// ParseGeocodeFilter to reject them when the filter is built.
+type GeocodeFilter []string
+
+// ParseGeocodeFilter returns a GeocodeFilter of the codes, or an error if a
+// code is not a 2, 4 or 7 digit SGC code.
+func ParseGeocodeFilter(codes ...string) (GeocodeFilter, error) {
+	f := make(GeocodeFilter, 0, len(codes))
+	for _, code := range codes {
+		code = strings.TrimSpace(code)
+		if _, ok := sgcLevel(code); !ok {
+			return nil, errors.New("Error: " + strconv.Quote(code) + " is not a 2, 4 or 7 digit SGC code")
+		}
+		f = append(f, code)
+	}
+	return f, nil
+}
+
+// Match returns true if any SGC geocode of the alert is related to a code of
+// the filter.
+func (f GeocodeFilter) Match(alert *cap.Alert) bool {
+	for _, code := range AlertGeocodes(alert) {
+		if _, ok := sgcLevel(code); !ok {
+			continue
+		}
+		for _, want := range f {
+			want = strings.TrimSpace(want)
+			if _, ok := sgcLevel(want); !ok {
+				continue
+			}
+			if strings.HasPrefix(code, want) || strings.HasPrefix(want, code) {
+				return true
+			}
+		}
+	}
+	return false
+}
@@ -0,0 +1,323 @@
+// Copyright (c) 2019 Tanner Ryan. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package naads
+
+// sgcProvinces maps the two digit SGC province/territory codes to their names
+// and postal abbreviations.
+var sgcProvinces = map[string]struct {
+	name string
+	abbr string
+}{
+	"10": {"Newfoundland and Labrador", "NL"},
+	"11": {"Prince Edward Island", "PE"},
+	"12": {"Nova Scotia", "NS"},
+	"13": {"New Brunswick", "NB"},
+	"24": {"Quebec", "QC"},
+	"35": {"Ontario", "ON"},
+	"46": {"Manitoba", "MB"},
+	"47": {"Saskatchewan", "SK"},
+	"48": {"Alberta", "AB"},
+	"59": {"British Columbia", "BC"},
+	"60": {"Yukon", "YT"},
+	"61": {"Northwest Territories", "NT"},
+	"62": {"Nunavut", "NU"},
+}
+
+// sgcDivisions maps the four digit SGC census division codes to their names.
+var sgcDivisions = map[string]string{
+	"1001": "Division No. 1",
+	"1002": "Division No. 2",
+	"1003": "Division No. 3",
+	"1004": "Division No. 4",
+	"1005": "Division No. 5",
+	"1006": "Division No. 6",
+	"1007": "Division No. 7",
+	"1008": "Division No. 8",
+	"1009": "Division No. 9",
+	"1010": "Division No. 10",
+	"1011": "Division No. 11",
+	"1101": "Kings",
+	"1102": "Queens",
+	"1103": "Prince",
+	"1201": "Shelburne",
+	"1202": "Yarmouth",
+	"1203": "Digby",
+	"1204": "Queens",
+	"1205": "Annapolis",
+	"1206": "Lunenburg",
+	"1207": "Kings",
+	"1208": "Hants",
+	"1209": "Halifax",
+	"1210": "Colchester",
+	"1211": "Cumberland",
+	"1212": "Pictou",
+	"1213": "Guysborough",
+	"1214": "Antigonish",
+	"1215": "Inverness",
+	"1216": "Richmond",
+	"1217": "Cape Breton",
+	"1218": "Victoria",
+	"1301": "Saint John",
+	"1302": "Charlotte",
+	"1303": "Sunbury",
+	"1304": "Queens",
+	"1305": "Kings",
+	"1306": "Albert",
+	"1307": "Westmorland",
+	"1308": "Kent",
+	"1309": "Northumberland",
+	"1310": "York",
+	"1311": "Carleton",
+	"1312": "Victoria",
+	"1313": "Madawaska",
+	"1314": "Restigouche",
+	"1315": "Gloucester",
+	"2401": "Les Îles-de-la-Madeleine",
+	"2402": "Le Rocher-Percé",
+	"2403": "La Côte-de-Gaspé",
+	"2404": "La Haute-Gaspésie",
+	"2405": "Bonaventure",
+	"2406": "Avignon",
+	"2407": "La Matapédia",
+	"2408": "La Matanie",
+	"2409": "La Mitis",
+	"2410": "Rimouski-Neigette",
+	"2411": "Les Basques",
+	"2412": "Rivière-du-Loup",
+	"2413": "Témiscouata",
+	"2414": "Kamouraska",
+	"2415": "Charlevoix-Est",
+	"2416": "Charlevoix",
+	"2417": "L'Islet",
+	"2418": "Montmagny",
+	"2419": "Bellechasse",
+	"2420": "L'Île-d'Orléans",
+	"2421": "La Côte-de-Beaupré",
+	"2422": "La Jacques-Cartier",
+	"2423": "Québec",
+	"2425": "Lévis",
+	"2426": "La Nouvelle-Beauce",
+	"2427": "Robert-Cliche",
+	"2428": "Les Etchemins",
+	"2429": "Beauce-Sartigan",
+	"2430": "Le Granit",
+	"2431": "Les Appalaches",
+	"2432": "L'Érable",
+	"2433": "Lotbinière",
+	"2434": "Portneuf",
+	"2435": "Mékinac",
+	"2436": "Shawinigan",
+	"2437": "Francheville",
+	"2438": "Bécancour",
+	"2439": "Arthabaska",
+	"2440": "Les Sources",
+	"2441": "Le Haut-Saint-François",
+	"2442": "Le Val-Saint-François",
+	"2443": "Sherbrooke",
+	"2444": "Coaticook",
+	"2445": "Memphrémagog",
+	"2446": "Brome-Missisquoi",
+	"2447": "La Haute-Yamaska",
+	"2448": "Acton",
+	"2449": "Drummond",
+	"2450": "Nicolet-Yamaska",
+	"2451": "Maskinongé",
+	"2452": "D'Autray",
+	"2453": "Pierre-De Saurel",
+	"2454": "Les Maskoutains",
+	"2455": "Rouville",
+	"2456": "Le Haut-Richelieu",
+	"2457": "La Vallée-du-Richelieu",
+	"2458": "Longueuil",
+	"2459": "Marguerite-D'Youville",
+	"2460": "L'Assomption",
+	"2461": "Joliette",
+	"2462": "Matawinie",
+	"2463": "Montcalm",
+	"2464": "Les Moulins",
+	"2465": "Laval",
+	"2466": "Montréal",
+	"2467": "Roussillon",
+	"2468": "Les Jardins-de-Napierville",
+	"2469": "Le Haut-Saint-Laurent",
+	"2470": "Beauharnois-Salaberry",
+	"2471": "Vaudreuil-Soulanges",
+	"2472": "Deux-Montagnes",
+	"2473": "Thérèse-De Blainville",
+	"2474": "Mirabel",
+	"2475": "La Rivière-du-Nord",
+	"2476": "Argenteuil",
+	"2477": "Les Pays-d'en-Haut",
+	"2478": "Les Laurentides",
+	"2479": "Antoine-Labelle",
+	"2480": "Papineau",
+	"2481": "Gatineau",
+	"2482": "Les Collines-de-l'Outaouais",
+	"2483": "La Vallée-de-la-Gatineau",
+	"2484": "Pontiac",
+	"2485": "Témiscamingue",
+	"2486": "Rouyn-Noranda",
+	"2487": "Abitibi-Ouest",
+	"2488": "Abitibi",
+	"2489": "La Vallée-de-l'Or",
+	"2490": "La Tuque",
+	"2491": "Le Domaine-du-Roy",
+	"2492": "Maria-Chapdelaine",
+	"2493": "Lac-Saint-Jean-Est",
+	"2494": "Le Saguenay-et-son-Fjord",
+	"2495": "La Haute-Côte-Nord",
+	"2496": "Manicouagan",
+	"2497": "Sept-Rivières--Caniapiscau",
+	"2498": "Minganie--Le Golfe-du-Saint-Laurent",
+	"2499": "Nord-du-Québec",
+	"3501": "Stormont, Dundas and Glengarry",
+	"3502": "Prescott and Russell",
+	"3506": "Ottawa",
+	"3507": "Leeds and Grenville",
+	"3509": "Lanark",
+	"3510": "Frontenac",
+	"3511": "Lennox and Addington",
+	"3512": "Hastings",
+	"3513": "Prince Edward",
+	"3514": "Northumberland",
+	"3515": "Peterborough",
+	"3516": "Kawartha Lakes",
+	"3518": "Durham",
+	"3519": "York",
+	"3520": "Toronto",
+	"3521": "Peel",
+	"3522": "Dufferin",
+	"3523": "Wellington",
+	"3524": "Halton",
+	"3525": "Hamilton",
+	"3526": "Niagara",
+	"3528": "Haldimand-Norfolk",
+	"3529": "Brant",
+	"3530": "Waterloo",
+	"3531": "Perth",
+	"3532": "Oxford",
+	"3534": "Elgin",
+	"3536": "Chatham-Kent",
+	"3537": "Essex",
+	"3538": "Lambton",
+	"3539": "Middlesex",
+	"3540": "Huron",
+	"3541": "Bruce",
+	"3542": "Grey",
+	"3543": "Simcoe",
+	"3544": "Muskoka",
+	"3546": "Haliburton",
+	"3547": "Renfrew",
+	"3548": "Nipissing",
+	"3549": "Parry Sound",
+	"3551": "Manitoulin",
+	"3552": "Sudbury",
+	"3553": "Greater Sudbury",
+	"3554": "Timiskaming",
+	"3556": "Cochrane",
+	"3557": "Algoma",
+	"3558": "Thunder Bay",
+	"3559": "Rainy River",
+	"3560": "Kenora",
+	"4601": "Division No. 1",
+	"4602": "Division No. 2",
+	"4603": "Division No. 3",
+	"4604": "Division No. 4",
+	"4605": "Division No. 5",
+	"4606": "Division No. 6",
+	"4607": "Division No. 7",
+	"4608": "Division No. 8",
+	"4609": "Division No. 9",
+	"4610": "Division No. 10",
+	"4611": "Division No. 11",
+	"4612": "Division No. 12",
+	"4613": "Division No. 13",
+	"4614": "Division No. 14",
+	"4615": "Division No. 15",
+	"4616": "Division No. 16",
+	"4617": "Division No. 17",
+	"4618": "Division No. 18",
+	"4619": "Division No. 19",
+	"4620": "Division No. 20",
+	"4621": "Division No. 21",
+	"4622": "Division No. 22",
+	"4623": "Division No. 23",
+	"4701": "Division No. 1",
+	"4702": "Division No. 2",
+	"4703": "Division No. 3",
+	"4704": "Division No. 4",
+	"4705": "Division No. 5",
+	"4706": "Division No. 6",
+	"4707": "Division No. 7",
+	"4708": "Division No. 8",
+	"4709": "Division No. 9",
+	"4710": "Division No. 10",
+	"4711": "Division No. 11",
+	"4712": "Division No. 12",
+	"4713": "Division No. 13",
+	"4714": "Division No. 14",
+	"4715": "Division No. 15",
+	"4716": "Division No. 16",
+	"4717": "Division No. 17",
+	"4718": "Division No. 18",
+	"4801": "Division No. 1",
+	"4802": "Division No. 2",
+	"4803": "Division No. 3",
+	"4804": "Division No. 4",
+	"4805": "Division No. 5",
+	"4806": "Division No. 6",
+	"4807": "Division No. 7",
+	"4808": "Division No. 8",
+	"4809": "Division No. 9",
+	"4810": "Division No. 10",
+	"4811": "Division No. 11",
+	"4812": "Division No. 12",
+	"4813": "Division No. 13",
+	"4814": "Division No. 14",
+	"4815": "Division No. 15",
+	"4816": "Division No. 16",
+	"4817": "Division No. 17",
+	"4818": "Division No. 18",
+	"4819": "Division No. 19",
+	"5901": "East Kootenay",
+	"5903": "Central Kootenay",
+	"5905": "Kootenay Boundary",
+	"5907": "Okanagan-Similkameen",
+	"5909": "Fraser Valley",
+	"5915": "Greater Vancouver",
+	"5917": "Capital",
+	"5919": "Cowichan Valley",
+	"5921": "Nanaimo",
+	"5923": "Alberni-Clayoquot",
+	"5924": "Strathcona",
+	"5926": "Comox Valley",
+	"5927": "Powell River",
+	"5929": "Sunshine Coast",
+	"5931": "Squamish-Lillooet",
+	"5933": "Thompson-Nicola",
+	"5935": "Central Okanagan",
+	"5937": "North Okanagan",
+	"5939": "Columbia-Shuswap",
+	"5941": "Cariboo",
+	"5943": "Mount Waddington",
+	"5945": "Central Coast",
+	"5947": "Skeena-Queen Charlotte",
+	"5949": "Kitimat-Stikine",
+	"5951": "Bulkley-Nechako",
+	"5953": "Fraser-Fort George",
+	"5955": "Peace River",
+	"5957": "Stikine",
+	"5959": "Northern Rockies",
+	"6001": "Yukon",
+	"6101": "Region 1",
+	"6102": "Region 2",
+	"6103": "Region 3",
+	"6104": "Region 4",
+	"6105": "Region 5",
+	"6106": "Region 6",
+	"6204": "Qikiqtaaluk",
+	"6205": "Kivalliq",
+	"6208": "Kitikmeot",
+}
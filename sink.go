@@ -0,0 +1,287 @@
+// Copyright (c) 2019 Tanner Ryan. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package naads
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+)
+
+const (
+	defaultSinkTimeout    = 10 * time.Second // default timeout of a delivery attempt
+	defaultSinkAttempts   = 5                // default number of delivery attempts
+	defaultSinkBackoff    = 1 * time.Second  // default delay before the first retry
+	defaultSinkMaxBackoff = 1 * time.Minute  // default maximum delay between retries
+	defaultSinkQueueSize  = 64               // default number of queued deliveries
+)
+
+// Sink is a destination of forwarded messages. Deliver is called by the
+// workers of the output, one message at a time unless the output has several
+// workers; an error causes the delivery to be retried unless the error is
+// permanent (see Permanent). Deliver must return when the context is done.
+type Sink interface {
+	Deliver(ctx context.Context, msg *Message) error
+}
+
+// EventSink is a sink that also receives the control events of the client.
+// Events are delivered once (without retries) and in order by the first
+// worker of the output.
+type EventSink interface {
+	Sink
+	Event(ctx context.Context, ev *Event) error
+}
+
+// EventKind is the kind of a control event.
+type EventKind int
+
+// Control event kinds.
+const (
+	EventFeedUp   EventKind = iota // Feed connected
+	EventFeedDown                  // Feed disconnected
+	EventLocked                    // Client locked onto a feed
+	EventAllDown                   // No feeds are available
+)
+
+// eventNames are the display names of the event kinds.
+var eventNames = [...]string{"FEED_UP", "FEED_DOWN", "LOCKED", "ALL_DOWN"}
+
+// String returns the display name of the event kind.
+func (k EventKind) String() string {
+	if k < 0 || int(k) >= len(eventNames) {
+		return "UNKNOWN"
+	}
+	return eventNames[k]
+}
+
+// Event is a control event of the client, such as a feed disconnection or a
+// change of the locked feed.
+type Event struct {
+	Kind    EventKind // Kind of event
+	Feed    string    // Name of the feed concerned (empty if not applicable)
+	Time    time.Time // Time of the event
+	Message string    // Description of the event
+}
+
+// PermanentError is a delivery error that is not retried.
+type PermanentError struct {
+	Err error // Underlying error
+}
+
+// Error returns the underlying error message.
+func (e *PermanentError) Error() string {
+	return e.Err.Error()
+}
+
+// Permanent marks the delivery error as permanent, so the delivery is
+// dead-lettered without further attempts.
+func Permanent(err error) error {
+	if err == nil {
+		return nil
+	}
+	return &PermanentError{Err: err}
+}
+
+// Output drives the delivery of forwarded messages to a sink. Each output has
+// its own queue and workers, so a slow or failing sink never delays the client
+// or the other outputs. Failed deliveries are retried with exponential
+// backoff; deliveries that fail permanently, exhaust their attempts or
+// overflow the queue are parked in the dead-letter directory.
+type Output struct {
+	Name          string        // Name of the output (display purposes)
+	Sink          Sink          // Destination of the messages (REQUIRED)
+	Filter        Filter        // Filter selecting the alerts delivered (nil delivers all forwarded alerts)
+	Timeout       time.Duration // Timeout of each delivery attempt (defaults to 10 seconds)
+	MaxAttempts   int           // Maximum number of delivery attempts (defaults to 5)
+	Backoff       time.Duration // Delay before the first retry, doubled on each retry (defaults to 1 second)
+	MaxBackoff    time.Duration // Maximum delay between retries (defaults to 1 minute)
+	QueueSize     int           // Number of deliveries queued before deliveries are dead-lettered (defaults to 64)
+	Workers       int           // Number of concurrent deliveries (defaults to 1)
+	DeadLetterDir string        // Directory where failed deliveries are saved (empty discards them)
+	LogStatus     bool          // Indicator to log delivery failures to stdout
+	ch            chan *Message // Queued deliveries
+	events        chan *Event   // Queued control events
+	mu            sync.Mutex    // Mutex protecting the counters
+	countSent     int           // Count of successful deliveries
+	countRetried  int           // Count of retried delivery attempts
+	countFailed   int           // Count of failed deliveries
+}
+
+// start launches the delivery workers of the output. Only the first worker
+// handles control events, so they are delivered in order.
+func (o *Output) start() {
+	size := o.QueueSize
+	if size <= 0 {
+		size = defaultSinkQueueSize
+	}
+	o.ch = make(chan *Message, size)
+	o.events = make(chan *Event, size)
+	go func() {
+		for {
+			select {
+			case msg := <-o.ch:
+				o.deliver(msg)
+			case ev := <-o.events:
+				o.event(ev)
+			}
+		}
+	}()
+	for i := 1; i < o.Workers; i++ {
+		go func() {
+			for msg := range o.ch {
+				o.deliver(msg)
+			}
+		}()
+	}
+}
+
+// enqueue queues the message for delivery if it matches the filter. The
+// message is dead-lettered if the queue is full.
+func (o *Output) enqueue(msg *Message) {
+	if o.Filter != nil && !o.Filter.Match(msg.Alert) {
+		return
+	}
+	select {
+	case o.ch <- msg:
+	default:
+		o.fail(msg, 0, errors.New("Error: delivery queue is full"))
+	}
+}
+
+// enqueueEvent queues the control event if the sink receives events. Events
+// are discarded if the queue is full.
+func (o *Output) enqueueEvent(ev *Event) {
+	if _, ok := o.Sink.(EventSink); !ok {
+		return
+	}
+	select {
+	case o.events <- ev:
+	default:
+	}
+}
+
+// event passes the control event to the sink.
+func (o *Output) event(ev *Event) {
+	timeout := o.Timeout
+	if timeout <= 0 {
+		timeout = defaultSinkTimeout
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+	defer func() {
+		if r := recover(); r != nil && o.LogStatus {
+			log.Printf("%s [ERROR]  Sink panicked on %s event: %v\n", o.Name, ev.Kind, r)
+		}
+	}()
+	if err := o.Sink.(EventSink).Event(ctx, ev); err != nil && o.LogStatus {
+		log.Printf("%s [ERROR]  Delivery of %s event failed: %s\n", o.Name, ev.Kind, err)
+	}
+}
+
+// deliver passes the message to the sink, retrying with exponential backoff
+// until it is delivered, fails permanently or the attempts are exhausted.
+func (o *Output) deliver(msg *Message) {
+	timeout := o.Timeout
+	if timeout <= 0 {
+		timeout = defaultSinkTimeout
+	}
+	attempts := o.MaxAttempts
+	if attempts <= 0 {
+		attempts = defaultSinkAttempts
+	}
+	backoff := o.Backoff
+	if backoff <= 0 {
+		backoff = defaultSinkBackoff
+	}
+	maxBackoff := o.MaxBackoff
+	if maxBackoff <= 0 {
+		maxBackoff = defaultSinkMaxBackoff
+	}
+
+	for attempt := 1; ; attempt++ {
+		err := o.attempt(msg, timeout)
+		if err == nil {
+			o.count(&o.countSent)
+			return
+		}
+		_, permanent := err.(*PermanentError)
+		if permanent || attempt >= attempts {
+			o.fail(msg, attempt, err)
+			return
+		}
+		o.count(&o.countRetried)
+		if o.LogStatus {
+			log.Printf("%s [ERROR]  Delivery of %s failed (attempt %d of %d): %s\n", o.Name, msg.Alert.Identifier, attempt, attempts, err)
+		}
+		time.Sleep(backoff)
+		if backoff *= 2; backoff > maxBackoff {
+			backoff = maxBackoff
+		}
+	}
+}
+
+// attempt performs a single delivery with the timeout. A panicking sink is
+// treated as a permanent failure, so it cannot take down the client.
+func (o *Output) attempt(msg *Message, timeout time.Duration) (err error) {
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+	defer func() {
+		if r := recover(); r != nil {
+			err = Permanent(fmt.Errorf("Error: sink panicked: %v", r))
+		}
+	}()
+	return o.Sink.Deliver(ctx, msg)
+}
+
+// count increments the delivery counter.
+func (o *Output) count(counter *int) {
+	o.mu.Lock()
+	*counter++
+	o.mu.Unlock()
+}
+
+// counts returns the delivery counters (sent, retried and failed).
+func (o *Output) counts() (int, int, int) {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	return o.countSent, o.countRetried, o.countFailed
+}
+
+// fail counts, logs and dead-letters a failed delivery.
+func (o *Output) fail(msg *Message, attempts int, err error) {
+	o.count(&o.countFailed)
+	if o.LogStatus {
+		log.Printf("%s [ERROR]  Delivery of %s abandoned: %s\n", o.Name, msg.Alert.Identifier, err)
+	}
+	if o.DeadLetterDir == "" {
+		return
+	}
+	if derr := deadLetter(o.DeadLetterDir, o.Name, msg, attempts, err); derr != nil && o.LogStatus {
+		log.Printf("%s [ERROR]  Unable to dead-letter %s: %s\n", o.Name, msg.Alert.Identifier, derr)
+	}
+}
+
+// deadLetter saves an undeliverable message to the directory. The original
+// XML is written to a file named by the output and alert identifier,
+// alongside a .txt file recording the attempts and error.
+func deadLetter(dir, name string, msg *Message, attempts int, reason error) error {
+	if err := os.MkdirAll(dir, 0750); err != nil {
+		return err
+	}
+	now := time.Now().UTC()
+	base := fmt.Sprintf("%s-%s-%s", safeFilename(name), safeFilename(msg.Alert.Identifier), now.Format("20060102T150405.000Z"))
+	if err := ioutil.WriteFile(filepath.Join(dir, base+".xml"), msg.Raw, 0640); err != nil {
+		return err
+	}
+	info := fmt.Sprintf("Output: %s\nIdentifier: %s\nClass: %s\nFeed: %s\nTime: %s\nAttempts: %d\nReason: %s\n",
+		name, msg.Alert.Identifier, msg.Class, msg.Feed, now.Format(time.RFC3339Nano), attempts, reason)
+	return ioutil.WriteFile(filepath.Join(dir, base+".txt"), []byte(info), 0640)
+}
@@ -0,0 +1,288 @@
+// Copyright (c) 2019 Tanner Ryan. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package naads
+
+import (
+	"context"
+	"errors"
+	"net"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/thetannerryan/cap"
+)
+
+// Syslog severity levels (RFC 5424).
+const (
+	syslogEmergency = iota
+	syslogAlert
+	syslogCritical
+	syslogError
+	syslogWarning
+	syslogNotice
+	syslogInformational
+	syslogDebug
+)
+
+const (
+	defaultSyslogFacility = 16                 // default facility (local0)
+	defaultSyslogAppName  = "naads"            // default APP-NAME
+	defaultSyslogSDID     = "naads@32473"      // default structured data ID of alerts (documentation enterprise number)
+	syslogEventSDID       = "naadsEvent@32473" // structured data ID of control events
+	syslogMaxAreas        = 512                // maximum length of the areas parameter
+	syslogDialTimeout     = 10 * time.Second   // timeout establishing a connection
+	syslogMaxFacility     = 23                 // highest facility (local7)
+
+	// syslogTimeFormat is the TIMESTAMP format (RFC 5424 permits at most six
+	// fractional digits)
+	syslogTimeFormat = "2006-01-02T15:04:05.000000Z07:00"
+)
+
+// Syslog is a sink emitting messages and control events as RFC 5424 syslog
+// messages. Alerts carry a structured data element with the identifier,
+// event, severity, urgency and areas of the alert, and the most severe CAP
+// severity of the alert is mapped to the syslog severity:
+//
+//	Extreme  -> alert (1)
+//	Severe   -> critical (2)
+//	Moderate -> warning (4)
+//	Minor    -> notice (5)
+//	Unknown  -> informational (6)
+//
+// Messages sent over TCP are framed by octet counting (RFC 6587).
+type Syslog struct {
+	Network  string     // Network of the syslog server ("udp", "tcp" or "unix")
+	Address  string     // Address of the syslog server (host:port, or socket path such as /dev/log)
+	Facility *int       // Syslog facility, 0 (kern) to 23 (local7) (nil defaults to 16, local0)
+	Hostname string     // HOSTNAME of the messages (defaults to the system hostname)
+	AppName  string     // APP-NAME of the messages (defaults to "naads")
+	SDID     string     // Structured data ID of alerts (defaults to "naads@32473"; use your enterprise number)
+	mu       sync.Mutex // Mutex protecting the connection
+	conn     net.Conn   // Connection to the syslog server
+	network  string     // Network of the established connection
+}
+
+// Deliver emits the message as a syslog message.
+func (s *Syslog) Deliver(ctx context.Context, msg *Message) error {
+	severity := syslogInformational
+	if len(msg.Alert.Info) > 0 && msg.Class == ClassAlert {
+		severity = syslogSeverity(maxSeverity(msg.Alert))
+	}
+	sdid := s.SDID
+	if sdid == "" {
+		sdid = defaultSyslogSDID
+	}
+	return s.write(ctx, severity, msg.Class.String(), msg.Received, s.alertData(sdid, msg), alertSummary(msg.Alert))
+}
+
+// Event emits the control event as a syslog message.
+func (s *Syslog) Event(ctx context.Context, ev *Event) error {
+	severity := syslogNotice
+	switch ev.Kind {
+	case EventFeedDown:
+		severity = syslogError
+	case EventAllDown:
+		severity = syslogCritical
+	}
+	data := "[" + syslogEventSDID + ` kind="` + sdEscape(ev.Kind.String()) + `"`
+	if ev.Feed != "" {
+		data += ` feed="` + sdEscape(ev.Feed) + `"`
+	}
+	data += "]"
+	return s.write(ctx, severity, ev.Kind.String(), ev.Time, data, ev.Message)
+}
+
+// Close closes the connection to the syslog server.
+func (s *Syslog) Close() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.conn == nil {
+		return nil
+	}
+	err := s.conn.Close()
+	s.conn = nil
+	return err
+}
+
+// alertData returns the structured data element of the alert.
+func (s *Syslog) alertData(sdid string, msg *Message) string {
+	var b strings.Builder
+	b.WriteString("[" + sdid)
+	param := func(name, val string) {
+		b.WriteString(" " + name + `="` + sdEscape(val) + `"`)
+	}
+	param("identifier", msg.Alert.Identifier)
+	param("sender", msg.Alert.Sender)
+	param("status", msg.Alert.Status.String())
+	param("msgType", msg.Alert.MsgType.String())
+	if len(msg.Alert.Info) > 0 {
+		info := primaryInfo(msg.Alert)
+		param("event", info.Event)
+		param("severity", maxSeverity(msg.Alert).String())
+		param("urgency", info.Urgency.String())
+		var areas []string
+		for _, area := range info.Area {
+			areas = append(areas, area.AreaDesc)
+		}
+		joined := strings.Join(areas, "; ")
+		if len(joined) > syslogMaxAreas {
+			joined = truncateUTF8(joined, syslogMaxAreas-3) + "..."
+		}
+		param("areas", joined)
+	}
+	if msg.Feed != "" {
+		param("feed", msg.Feed)
+	}
+	b.WriteString("]")
+	return b.String()
+}
+
+// write formats and sends a syslog message, reconnecting if necessary.
+func (s *Syslog) write(ctx context.Context, severity int, msgID string, t time.Time, data, text string) error {
+	facility := defaultSyslogFacility
+	if s.Facility != nil {
+		facility = *s.Facility
+	}
+	if facility < 0 || facility > syslogMaxFacility {
+		return Permanent(errors.New("Error: syslog facility " + strconv.Itoa(facility) + " is not between 0 and 23"))
+	}
+	hostname := s.Hostname
+	if hostname == "" {
+		hostname, _ = os.Hostname()
+	}
+	appName := s.AppName
+	if appName == "" {
+		appName = defaultSyslogAppName
+	}
+	if t.IsZero() {
+		t = time.Now()
+	}
+	line := "<" + strconv.Itoa(facility*8+severity) + ">1 " +
+		t.UTC().Format(syslogTimeFormat) + " " +
+		syslogHeaderField(hostname, 255) + " " +
+		syslogHeaderField(appName, 48) + " " +
+		strconv.Itoa(os.Getpid()) + " " +
+		syslogHeaderField(msgID, 32) + " " +
+		data
+	if text != "" {
+		// BOM indicates UTF-8 message text
+		line += " \uFEFF" + text
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.conn == nil {
+		if err := s.dial(ctx); err != nil {
+			return err
+		}
+	}
+	frame := []byte(line)
+	if s.network == "tcp" {
+		frame = []byte(strconv.Itoa(len(line)) + " " + line)
+	} else if s.network == "unix" {
+		frame = append(frame, '\n')
+	}
+	if deadline, ok := ctx.Deadline(); ok {
+		s.conn.SetWriteDeadline(deadline)
+	}
+	if _, err := s.conn.Write(frame); err != nil {
+		// reconnect on the next attempt
+		s.conn.Close()
+		s.conn = nil
+		return err
+	}
+	return nil
+}
+
+// dial connects to the syslog server.
+func (s *Syslog) dial(ctx context.Context) error {
+	dialer := &net.Dialer{Timeout: syslogDialTimeout}
+	network := s.Network
+	switch network {
+	case "udp", "tcp":
+	case "unix", "unixgram":
+		// local syslog daemons listen on datagram sockets
+		if conn, err := dialer.DialContext(ctx, "unixgram", s.Address); err == nil {
+			s.conn, s.network = conn, "unixgram"
+			return nil
+		}
+		network = "unix"
+	default:
+		return Permanent(errors.New("Error: unsupported syslog network " + strconv.Quote(s.Network)))
+	}
+	conn, err := dialer.DialContext(ctx, network, s.Address)
+	if err != nil {
+		return err
+	}
+	s.conn, s.network = conn, network
+	return nil
+}
+
+// syslogSeverity maps the CAP severity to the syslog severity.
+func syslogSeverity(severity cap.Severity) int {
+	switch severity {
+	case cap.SeverityExtreme:
+		return syslogAlert
+	case cap.SeveritySevere:
+		return syslogCritical
+	case cap.SeverityModerate:
+		return syslogWarning
+	case cap.SeverityMinor:
+		return syslogNotice
+	}
+	return syslogInformational
+}
+
+// syslogHeaderField returns the value as an RFC 5424 header field (printable
+// US-ASCII without spaces, limited in length), or NILVALUE if it is empty.
+func syslogHeaderField(val string, max int) string {
+	field := strings.Map(func(r rune) rune {
+		if r < 33 || r > 126 {
+			return -1
+		}
+		return r
+	}, val)
+	if len(field) > max {
+		field = field[:max]
+	}
+	if field == "" {
+		// NILVALUE
+		return "-"
+	}
+	return field
+}
+
+// sdEscape escapes the characters of a structured data parameter value.
+func sdEscape(val string) string {
+	return strings.NewReplacer(`\`, `\\`, `"`, `\"`, `]`, `\]`).Replace(val)
+}
+
+// alertSummary returns a one line summary of the alert.
+func alertSummary(alert *cap.Alert) string {
+	if len(alert.Info) == 0 {
+		return alert.Status.String() + " " + alert.MsgType.String() + " " + alert.Identifier
+	}
+	info := primaryInfo(alert)
+	summary := info.Headline
+	if summary == "" {
+		summary = info.Event
+	}
+	return strings.Join(strings.Fields(alert.MsgType.String()+": "+summary), " ")
+}
+
+// truncateUTF8 truncates the string to at most n bytes without splitting a
+// UTF-8 sequence.
+func truncateUTF8(val string, n int) string {
+	if len(val) <= n {
+		return val
+	}
+	for n > 0 && val[n]&0xC0 == 0x80 {
+		n--
+	}
+	return val[:n]
+}
@@ -0,0 +1,141 @@
+// Copyright (c) 2019 Tanner Ryan. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package naads
+
+import (
+	"bytes"
+	"crypto/x509"
+	"encoding/pem"
+	"errors"
+	"io/ioutil"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+)
+
+const defaultExpiryWarning = 30 * 24 * time.Hour // default warning period before signing certificate expiry
+
+// TrustStore is a local store of trusted certificates used to validate the
+// certificates signing NAADS messages. Self-signed certificates found in Dir
+// are trusted roots (e.g. the Pelmorex issuing CA); other certificates are
+// used as intermediates when building the chain.
+type TrustStore struct {
+	Dir           string        // Directory of PEM files (*.pem, *.crt) containing trusted certificates
+	Subjects      []string      // Pinned signing certificate subjects, matched against the common name or full subject (empty allows any subject)
+	ExpiryWarning time.Duration // Warn when the signing certificate expires within this duration (defaults to 30 days)
+
+	once          sync.Once           // Ensures the store is loaded once
+	err           error               // Error encountered when loading the store
+	roots         *x509.CertPool      // Trusted root certificates
+	intermediates []*x509.Certificate // Intermediate certificates
+}
+
+// Load reads the certificates of the store from Dir. It is called
+// automatically on first use; calling it directly allows configuration errors
+// to be reported at startup.
+func (t *TrustStore) Load() error {
+	t.once.Do(func() {
+		t.err = t.load()
+	})
+	return t.err
+}
+
+// load reads every PEM certificate of the store's directory.
+func (t *TrustStore) load() error {
+	t.roots = x509.NewCertPool()
+
+	files, err := ioutil.ReadDir(t.Dir)
+	if err != nil {
+		return err
+	}
+	count := 0
+	for _, file := range files {
+		ext := strings.ToLower(filepath.Ext(file.Name()))
+		if file.IsDir() || (ext != ".pem" && ext != ".crt") {
+			continue
+		}
+		data, err := ioutil.ReadFile(filepath.Join(t.Dir, file.Name()))
+		if err != nil {
+			return err
+		}
+		for {
+			var block *pem.Block
+			block, data = pem.Decode(data)
+			if block == nil {
+				break
+			}
+			if block.Type != "CERTIFICATE" {
+				continue
+			}
+			cert, err := x509.ParseCertificate(block.Bytes)
+			if err != nil {
+				return errors.New("Error: cannot parse certificate in " + file.Name() + ": " + err.Error())
+			}
+			if bytes.Equal(cert.RawIssuer, cert.RawSubject) {
+				t.roots.AddCert(cert)
+			} else {
+				t.intermediates = append(t.intermediates, cert)
+			}
+			count++
+		}
+	}
+	if count == 0 {
+		return errors.New("Error: no certificates found in " + t.Dir)
+	}
+	return nil
+}
+
+// Verify checks that the signing certificate chains to a trusted root of the
+// store (using the store's intermediates and those provided with the
+// signature), is valid at the time, and matches a pinned subject.
+func (t *TrustStore) Verify(cert *x509.Certificate, intermediates []*x509.Certificate, at time.Time) error {
+	if err := t.Load(); err != nil {
+		return err
+	}
+	if cert == nil {
+		return errors.New("Error: no signing certificate")
+	}
+
+	pool := x509.NewCertPool()
+	for _, c := range t.intermediates {
+		pool.AddCert(c)
+	}
+	for _, c := range intermediates {
+		pool.AddCert(c)
+	}
+	_, err := cert.Verify(x509.VerifyOptions{
+		Roots:         t.roots,
+		Intermediates: pool,
+		CurrentTime:   at,
+		KeyUsages:     []x509.ExtKeyUsage{x509.ExtKeyUsageAny},
+	})
+	if err != nil {
+		return errors.New("Error: untrusted signing certificate: " + err.Error())
+	}
+
+	if len(t.Subjects) > 0 {
+		pinned := false
+		for _, subject := range t.Subjects {
+			if subject == cert.Subject.CommonName || subject == cert.Subject.String() {
+				pinned = true
+				break
+			}
+		}
+		if !pinned {
+			return errors.New("Error: signing certificate subject " + cert.Subject.String() + " is not pinned")
+		}
+	}
+	return nil
+}
+
+// expiring returns true if the certificate expires within the warning period.
+func (t *TrustStore) expiring(cert *x509.Certificate, now time.Time) bool {
+	warning := t.ExpiryWarning
+	if warning <= 0 {
+		warning = defaultExpiryWarning
+	}
+	return cert.NotAfter.Sub(now) < warning
+}
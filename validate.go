@@ -0,0 +1,251 @@
+// Copyright (c) 2019 Tanner Ryan. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package naads
+
+import (
+	"strconv"
+	"strings"
+
+	"github.com/thetannerryan/cap"
+)
+
+// CanadaBounds is the expected extent of NAADS geometry. Shapes lying entirely
+// outside of it, but inside of it once latitude and longitude are exchanged,
+// are considered to have swapped coordinates.
+var CanadaBounds = Bounds{MinLat: 40, MinLon: -142, MaxLat: 84, MaxLon: -51}
+
+const maxCircleRadius = 20037.5 // half the circumference of the Earth (km)
+
+// GeometryError describes a problem with the polygon or a circle of a CAP
+// area. Fatal problems make the shape unusable, and the shape is omitted from
+// the normalized geometry; other problems are corrected by normalization.
+type GeometryError struct {
+	Shape   string // Shape with the problem ("polygon" or "circle N")
+	Point   int    // Index of the offending point within the shape (-1 if not applicable)
+	Problem string // Description of the problem
+	Fatal   bool   // Indicator that the shape could not be corrected
+}
+
+// Error returns a description of the problem, including its location.
+func (e *GeometryError) Error() string {
+	loc := e.Shape
+	if e.Point >= 0 {
+		loc += " point " + strconv.Itoa(e.Point)
+	}
+	return loc + ": " + e.Problem
+}
+
+// AreaGeometry is the validated, normalized geometry of a CAP area. Polygons
+// are closed rings with counterclockwise winding (RFC 7946 exterior rings).
+type AreaGeometry struct {
+	Polygons []Polygon // Normalized polygons
+	Circles  []Circle  // Validated circles
+}
+
+// Shapes returns the polygons and circles of the geometry.
+func (g *AreaGeometry) Shapes() []Shape {
+	var shapes []Shape
+	for _, poly := range g.Polygons {
+		shapes = append(shapes, poly)
+	}
+	for _, circle := range g.Circles {
+		shapes = append(shapes, circle)
+	}
+	return shapes
+}
+
+// ValidateArea parses the polygon and circles of the CAP area, reporting every
+// geometry problem found, and returns the normalized geometry. Normalization
+// closes unclosed rings, removes repeated points, exchanges swapped
+// coordinates and orients rings counterclockwise. Self-intersecting rings are
+// fatal: their intended coverage is ambiguous, and correcting them (for
+// example by their convex hull) could enlarge the area of the alert.
+func ValidateArea(area *cap.Area) (*AreaGeometry, []*GeometryError) {
+	geometry := &AreaGeometry{}
+	var problems []*GeometryError
+
+	if val := strings.TrimSpace(area.Polygon.String()); val != "" {
+		poly, errs := normalizePolygon(val)
+		problems = append(problems, errs...)
+		if poly != nil {
+			geometry.Polygons = append(geometry.Polygons, poly)
+		}
+	}
+	for i, val := range area.Circle {
+		if strings.TrimSpace(val) == "" {
+			continue
+		}
+		circle, errs := normalizeCircle("circle "+strconv.Itoa(i), val)
+		problems = append(problems, errs...)
+		if circle != nil {
+			geometry.Circles = append(geometry.Circles, *circle)
+		}
+	}
+	return geometry, problems
+}
+
+// normalizePolygon validates and normalizes a CAP polygon. A nil polygon is
+// returned if the polygon has a fatal problem.
+func normalizePolygon(val string) (Polygon, []*GeometryError) {
+	var problems []*GeometryError
+	report := func(point int, problem string, fatal bool) {
+		problems = append(problems, &GeometryError{Shape: "polygon", Point: point, Problem: problem, Fatal: fatal})
+	}
+
+	// parse points
+	var poly Polygon
+	for i, pair := range strings.Fields(val) {
+		p, err := parsePoint(pair)
+		if err != nil {
+			report(i, "cannot parse coordinate pair "+strconv.Quote(pair), true)
+			return nil, problems
+		}
+		poly = append(poly, p)
+	}
+
+	// coordinates
+	switch swapped(poly) {
+	case 1:
+		report(-1, "latitude and longitude appear to be swapped", false)
+		for i := range poly {
+			poly[i] = Point{Lat: poly[i].Lon, Lon: poly[i].Lat}
+		}
+	case -1:
+		for i, p := range poly {
+			if !validPoint(p) {
+				report(i, "coordinate out of range", true)
+				return nil, problems
+			}
+		}
+	}
+
+	// closure
+	if len(poly) > 0 && poly[0] != poly[len(poly)-1] {
+		report(len(poly)-1, "ring is not closed", false)
+		poly = append(poly, poly[0])
+	}
+
+	// repeated points
+	deduped := Polygon{poly[0]}
+	for i := 1; i < len(poly); i++ {
+		if poly[i] == deduped[len(deduped)-1] {
+			report(i, "repeated point", false)
+			continue
+		}
+		deduped = append(deduped, poly[i])
+	}
+	poly = deduped
+	if len(poly) < 4 {
+		report(-1, "ring has fewer than 3 distinct points", true)
+		return nil, problems
+	}
+
+	// self-intersection
+	if i, j, ok := selfIntersection(poly); ok {
+		report(i, "edge "+strconv.Itoa(i)+" intersects edge "+strconv.Itoa(j), true)
+		return nil, problems
+	}
+	if signedArea(poly) == 0 {
+		report(-1, "ring has zero area", true)
+		return nil, problems
+	}
+
+	// winding
+	if signedArea(poly) < 0 {
+		for i, j := 0, len(poly)-1; i < j; i, j = i+1, j-1 {
+			poly[i], poly[j] = poly[j], poly[i]
+		}
+	}
+	return poly, problems
+}
+
+// normalizeCircle validates a CAP circle. A nil circle is returned if the
+// circle has a fatal problem.
+func normalizeCircle(name, val string) (*Circle, []*GeometryError) {
+	var problems []*GeometryError
+	report := func(problem string, fatal bool) {
+		problems = append(problems, &GeometryError{Shape: name, Point: -1, Problem: problem, Fatal: fatal})
+	}
+
+	circle, err := ParseCircle(val)
+	if err != nil {
+		report("cannot parse circle "+strconv.Quote(val), true)
+		return nil, problems
+	}
+	switch swapped([]Point{circle.Center}) {
+	case 1:
+		report("latitude and longitude of center appear to be swapped", false)
+		circle.Center = Point{Lat: circle.Center.Lon, Lon: circle.Center.Lat}
+	case -1:
+		report("center out of range", true)
+		return nil, problems
+	}
+	if circle.Radius == 0 {
+		report("radius is zero", false)
+	}
+	if circle.Radius > maxCircleRadius {
+		report("radius exceeds half the circumference of the Earth", true)
+		return nil, problems
+	}
+	return &circle, problems
+}
+
+// validPoint returns true if the point is within the range of valid
+// coordinates.
+func validPoint(p Point) bool {
+	return p.Lat >= -90 && p.Lat <= 90 && p.Lon >= -180 && p.Lon <= 180
+}
+
+// swapped returns 1 if the points appear to have swapped coordinates, -1 if
+// the points are out of range (and exchanging them does not help), and 0 if
+// the points appear correct.
+func swapped(points []Point) int {
+	valid, invalid, validSwapped, outside, insideSwapped := true, true, true, true, true
+	for _, p := range points {
+		s := Point{Lat: p.Lon, Lon: p.Lat}
+		valid = valid && validPoint(p)
+		invalid = invalid && !validPoint(p)
+		validSwapped = validSwapped && validPoint(s)
+		outside = outside && !CanadaBounds.Contains(p)
+		insideSwapped = insideSwapped && CanadaBounds.Contains(s)
+	}
+	switch {
+	case !valid && validSwapped && (invalid || insideSwapped):
+		return 1
+	case !valid:
+		return -1
+	case outside && insideSwapped:
+		return 1
+	}
+	return 0
+}
+
+// signedArea returns twice the signed planar area of the closed ring
+// (positive for counterclockwise rings).
+func signedArea(poly Polygon) float64 {
+	area := 0.0
+	for i := 0; i+1 < len(poly); i++ {
+		area += poly[i].Lon*poly[i+1].Lat - poly[i+1].Lon*poly[i].Lat
+	}
+	return area
+}
+
+// selfIntersection returns the indexes of the first pair of non-adjacent edges
+// of the closed ring that intersect.
+func selfIntersection(poly Polygon) (int, int, bool) {
+	n := len(poly) - 1 // number of edges
+	for i := 0; i < n; i++ {
+		for j := i + 2; j < n; j++ {
+			if i == 0 && j == n-1 {
+				// first and last edges share the closing point
+				continue
+			}
+			if segmentsIntersect(poly[i], poly[i+1], poly[j], poly[j+1]) {
+				return i, j, true
+			}
+		}
+	}
+	return 0, 0, false
+}
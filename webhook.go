@@ -0,0 +1,125 @@
+// Copyright (c) 2019 Tanner Ryan. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package naads
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"io"
+	"io/ioutil"
+	"net/http"
+)
+
+// WebhookFormat is the body format of webhook deliveries.
+type WebhookFormat int
+
+// Webhook body formats.
+const (
+	WebhookJSON WebhookFormat = iota // JSON encoding of the alert (cap package JSON tags)
+	WebhookXML                       // Original XML document of the alert
+)
+
+// webhookFormatNames are the display names of the webhook formats.
+var webhookFormatNames = [...]string{"JSON", "XML"}
+
+// String returns the display name of the format.
+func (f WebhookFormat) String() string {
+	if f < 0 || int(f) >= len(webhookFormatNames) {
+		return "UNKNOWN"
+	}
+	return webhookFormatNames[f]
+}
+
+// webhook request headers
+const (
+	webhookSignatureHeader   = "X-NAADS-Signature" // HMAC-SHA256 of the body
+	webhookIdempotencyHeader = "Idempotency-Key"   // alert identifier
+	webhookClassHeader       = "X-NAADS-Class"     // message class
+	webhookFeedHeader        = "X-NAADS-Feed"      // name of the feed
+)
+
+// Webhook is a sink posting messages to an HTTP endpoint. Network errors,
+// server errors and rate limiting are retried by the output; other error
+// responses are permanent failures.
+//
+// Requests carry an Idempotency-Key header (the alert identifier), allowing
+// the endpoint to discard retried deliveries it has already processed. When a
+// Secret is configured, the X-NAADS-Signature header holds the hex encoded
+// HMAC-SHA256 of the body ("sha256=<hex>").
+type Webhook struct {
+	URL     string            // Endpoint receiving POST requests (REQUIRED)
+	Format  WebhookFormat     // Body format of the requests
+	Secret  string            // Key of the HMAC-SHA256 body signature (empty disables signing)
+	Headers map[string]string // Additional request headers (e.g. Authorization)
+	Client  *http.Client      // HTTP client performing requests (defaults to http.DefaultClient)
+}
+
+// Deliver posts the message to the endpoint.
+func (w *Webhook) Deliver(ctx context.Context, msg *Message) error {
+	body, err := w.body(msg)
+	if err != nil {
+		return Permanent(err)
+	}
+	req, err := http.NewRequest(http.MethodPost, w.URL, bytes.NewReader(body))
+	if err != nil {
+		return Permanent(err)
+	}
+	req = req.WithContext(ctx)
+	if w.Format == WebhookXML {
+		req.Header.Set("Content-Type", "application/xml")
+	} else {
+		req.Header.Set("Content-Type", "application/json")
+	}
+	req.Header.Set("User-Agent", "naads/"+version)
+	req.Header.Set(webhookIdempotencyHeader, msg.Alert.Identifier)
+	req.Header.Set(webhookClassHeader, msg.Class.String())
+	req.Header.Set(webhookFeedHeader, msg.Feed)
+	if w.Secret != "" {
+		req.Header.Set(webhookSignatureHeader, "sha256="+SignWebhook(w.Secret, body))
+	}
+	for key, val := range w.Headers {
+		req.Header.Set(key, val)
+	}
+
+	client := w.Client
+	if client == nil {
+		client = http.DefaultClient
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	io.Copy(ioutil.Discard, io.LimitReader(resp.Body, 64*1024))
+	resp.Body.Close()
+	switch {
+	case resp.StatusCode >= 200 && resp.StatusCode < 300:
+		return nil
+	case resp.StatusCode >= 500 || resp.StatusCode == http.StatusTooManyRequests || resp.StatusCode == http.StatusRequestTimeout:
+		return errors.New("Error: endpoint responded " + resp.Status)
+	}
+	return Permanent(errors.New("Error: endpoint rejected delivery: " + resp.Status))
+}
+
+// body encodes the message in the format of the webhook.
+func (w *Webhook) body(msg *Message) ([]byte, error) {
+	if w.Format == WebhookXML {
+		return msg.Raw, nil
+	}
+	return json.Marshal(msg.Alert)
+}
+
+// SignWebhook returns the hex encoded HMAC-SHA256 of the body, as sent in the
+// X-NAADS-Signature header. Receivers compute it with their copy of the
+// secret and compare it (in constant time) to the header.
+func SignWebhook(secret string, body []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write(body)
+	return hex.EncodeToString(mac.Sum(nil))
+}
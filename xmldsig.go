@@ -0,0 +1,569 @@
+// Copyright (c) 2019 Tanner Ryan. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package naads
+
+import (
+	"bytes"
+	"crypto"
+	"crypto/rsa"
+	_ "crypto/sha1"   // SHA-1 digests
+	_ "crypto/sha256" // SHA-256 digests
+	_ "crypto/sha512" // SHA-512 digests
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/xml"
+	"errors"
+	"io"
+	"sort"
+	"strings"
+)
+
+// XML digital signature namespaces and algorithm identifiers
+const (
+	nsXMLDSig = "http://www.w3.org/2000/09/xmldsig#"
+	nsXML     = "http://www.w3.org/XML/1998/namespace"
+
+	algEnveloped       = "http://www.w3.org/2000/09/xmldsig#enveloped-signature"
+	algC14N            = "http://www.w3.org/TR/2001/REC-xml-c14n-20010315"
+	algC14NComments    = "http://www.w3.org/TR/2001/REC-xml-c14n-20010315#WithComments"
+	algExcC14N         = "http://www.w3.org/2001/10/xml-exc-c14n#"
+	algExcC14NComments = "http://www.w3.org/2001/10/xml-exc-c14n#WithComments"
+	algSHA1            = "http://www.w3.org/2000/09/xmldsig#sha1"
+	algSHA256          = "http://www.w3.org/2001/04/xmlenc#sha256"
+	algSHA512          = "http://www.w3.org/2001/04/xmlenc#sha512"
+	algRSASHA1         = "http://www.w3.org/2000/09/xmldsig#rsa-sha1"
+	algRSASHA256       = "http://www.w3.org/2001/04/xmldsig-more#rsa-sha256"
+	algRSASHA512       = "http://www.w3.org/2001/04/xmldsig-more#rsa-sha512"
+)
+
+// digestAlgorithms maps digest and signature method identifiers to hashes.
+var digestAlgorithms = map[string]crypto.Hash{
+	algSHA1:      crypto.SHA1,
+	algSHA256:    crypto.SHA256,
+	algSHA512:    crypto.SHA512,
+	algRSASHA1:   crypto.SHA1,
+	algRSASHA256: crypto.SHA256,
+	algRSASHA512: crypto.SHA512,
+}
+
+// SignaturePolicy is the action the client takes on the XML digital signature
+// of incoming messages. Policies other than SignaturePass require a TrustStore:
+// the signing certificate is embedded in the message, so without one any
+// self-signed certificate would pass.
+type SignaturePolicy int
+
+const (
+	// SignaturePass :: Do not verify signatures; pass every message through
+	SignaturePass SignaturePolicy = 0
+	// SignatureFlag :: Verify signatures and certificates; deliver invalid messages, flagging them
+	SignatureFlag SignaturePolicy = 1
+	// SignatureReject :: Verify signatures and certificates; drop invalid messages
+	SignatureReject SignaturePolicy = 2
+)
+
+// String returns the display name of the SignaturePolicy.
+func (p SignaturePolicy) String() string {
+	switch p {
+	case SignaturePass:
+		return "PASS"
+	case SignatureFlag:
+		return "FLAG"
+	case SignatureReject:
+		return "REJECT"
+	}
+	return "UNKNOWN"
+}
+
+// Verification is the result of verifying the XML digital signature of a
+// message.
+type Verification struct {
+	Valid         bool                // Indicator that the digest, signature (and trust chain, if checked) are valid
+	Err           error               // Reason the signature is invalid (nil if valid)
+	Certificate   *x509.Certificate   // Signing certificate from KeyInfo (nil if absent or unparsable)
+	Intermediates []*x509.Certificate // Additional certificates from KeyInfo
+}
+
+// VerifySignature verifies the enveloped XML digital signatures of the raw CAP
+// document: the document (without its Signature elements) is canonicalized and
+// its digest compared with the DigestValue, then the canonicalized SignedInfo
+// is checked against the SignatureValue using the RSA key of the certificate
+// in KeyInfo. The certificate itself is not trusted by this function.
+//
+// NAADS messages carry two signatures, Pelmorex's followed by the issuer's,
+// both computed over the document without any Signature element. Every
+// signature must be valid; the certificates of the first are reported.
+func VerifySignature(raw []byte) *Verification {
+	v := &Verification{}
+	v.Err = v.verify(raw)
+	v.Valid = v.Err == nil
+	return v
+}
+
+// verify performs the signature verification, recording the certificates of
+// KeyInfo (if they could be parsed) and returning any verification error.
+func (v *Verification) verify(raw []byte) error {
+	root, err := parseNodes(raw)
+	if err != nil {
+		return err
+	}
+	sigs := root.children(nsXMLDSig, "Signature")
+	if len(sigs) == 0 {
+		return errors.New("Error: message is not signed")
+	}
+	exclude := make(map[*node]bool)
+	for _, sig := range sigs {
+		exclude[sig] = true
+	}
+	for i, sig := range sigs {
+		cert, intermediates, err := checkSignature(root, sig, exclude)
+		if i == 0 {
+			v.Certificate, v.Intermediates = cert, intermediates
+		}
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// checkSignature verifies a single signature of the document, omitting the
+// excluded Signature elements from the digest. The certificates of KeyInfo
+// are returned if they could be parsed.
+func checkSignature(root, sig *node, exclude map[*node]bool) (*x509.Certificate, []*x509.Certificate, error) {
+	var cert *x509.Certificate
+	var intermediates []*x509.Certificate
+	fail := func(msg string) (*x509.Certificate, []*x509.Certificate, error) {
+		return cert, intermediates, errors.New(msg)
+	}
+
+	signedInfo := sig.child(nsXMLDSig, "SignedInfo")
+	if signedInfo == nil {
+		return fail("Error: signature has no SignedInfo")
+	}
+
+	// signing certificate (first), followed by any intermediates
+	x509Data := sig.path(nsXMLDSig, "KeyInfo", "X509Data")
+	if x509Data == nil || x509Data.child(nsXMLDSig, "X509Certificate") == nil {
+		return fail("Error: signature has no X509Certificate")
+	}
+	for _, certNode := range x509Data.children(nsXMLDSig, "X509Certificate") {
+		der, err := base64.StdEncoding.DecodeString(stripSpace(certNode.text()))
+		if err != nil {
+			return fail("Error: cannot decode X509Certificate: " + err.Error())
+		}
+		parsed, err := x509.ParseCertificate(der)
+		if err != nil {
+			return fail("Error: cannot parse X509Certificate: " + err.Error())
+		}
+		if cert == nil {
+			cert = parsed
+		} else {
+			intermediates = append(intermediates, parsed)
+		}
+	}
+
+	// reference digest
+	ref := signedInfo.child(nsXMLDSig, "Reference")
+	if ref == nil {
+		return fail("Error: signature has no Reference")
+	}
+	if uri := ref.attr("", "URI"); uri != "" {
+		return fail("Error: unsupported Reference URI " + uri)
+	}
+	canon := &canonicalizer{exclude: exclude}
+	transforms := ref.child(nsXMLDSig, "Transforms")
+	if transforms != nil {
+		enveloped := false
+		for _, t := range transforms.children(nsXMLDSig, "Transform") {
+			switch alg := t.attr("", "Algorithm"); alg {
+			case algEnveloped:
+				enveloped = true
+			case algExcC14N, algExcC14NComments, algC14N, algC14NComments:
+				canon.configure(t, alg)
+			default:
+				return fail("Error: unsupported transform " + alg)
+			}
+		}
+		if !enveloped {
+			return fail("Error: signature is not enveloped")
+		}
+	}
+	hash, ok := digestAlgorithms[ref.path(nsXMLDSig, "DigestMethod").attr("", "Algorithm")]
+	if !ok {
+		return fail("Error: unsupported digest method")
+	}
+	h := hash.New()
+	canon.canonicalize(h, root)
+	want, err := base64.StdEncoding.DecodeString(stripSpace(ref.path(nsXMLDSig, "DigestValue").text()))
+	if err != nil {
+		return fail("Error: cannot decode DigestValue: " + err.Error())
+	}
+	if !bytes.Equal(h.Sum(nil), want) {
+		return fail("Error: digest mismatch")
+	}
+
+	// signature value
+	method := signedInfo.child(nsXMLDSig, "CanonicalizationMethod")
+	if method == nil {
+		return fail("Error: signature has no CanonicalizationMethod")
+	}
+	canon = &canonicalizer{}
+	switch alg := method.attr("", "Algorithm"); alg {
+	case algExcC14N, algExcC14NComments, algC14N, algC14NComments:
+		canon.configure(method, alg)
+	default:
+		return fail("Error: unsupported canonicalization method " + alg)
+	}
+	alg := signedInfo.path(nsXMLDSig, "SignatureMethod").attr("", "Algorithm")
+	hash, ok = digestAlgorithms[alg]
+	if !ok || !strings.Contains(alg, "rsa-") {
+		return fail("Error: unsupported signature method " + alg)
+	}
+	h = hash.New()
+	canon.canonicalize(h, signedInfo)
+	value, err := base64.StdEncoding.DecodeString(stripSpace(sig.path(nsXMLDSig, "SignatureValue").text()))
+	if err != nil {
+		return fail("Error: cannot decode SignatureValue: " + err.Error())
+	}
+	key, ok := cert.PublicKey.(*rsa.PublicKey)
+	if !ok {
+		return fail("Error: signing certificate does not have an RSA key")
+	}
+	if err := rsa.VerifyPKCS1v15(key, hash, h.Sum(nil), value); err != nil {
+		return fail("Error: signature mismatch")
+	}
+	return cert, intermediates, nil
+}
+
+// stripSpace removes all whitespace from base64 element content.
+func stripSpace(val string) string {
+	return strings.Join(strings.Fields(val), "")
+}
+
+// node is an element of a parsed XML document, retaining the namespace
+// prefixes and declarations required for canonicalization.
+type node struct {
+	name    xml.Name      // Element name (Space is the prefix)
+	attrs   []xml.Attr    // Attributes, including namespace declarations (Space is the prefix)
+	content []interface{} // Child elements (*node) and character data (string)
+	parent  *node         // Parent element (nil for the document element)
+}
+
+// parseNodes parses the XML document into a tree of nodes, returning the
+// document element. Comments, processing instructions and directives are
+// discarded.
+func parseNodes(raw []byte) (*node, error) {
+	decoder := xml.NewDecoder(bytes.NewReader(raw))
+	var root, current *node
+	for {
+		token, err := decoder.RawToken()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+		switch t := token.(type) {
+		case xml.StartElement:
+			n := &node{name: t.Name, attrs: t.Copy().Attr, parent: current}
+			if current == nil {
+				if root != nil {
+					return nil, errors.New("Error: multiple document elements")
+				}
+				root = n
+			} else {
+				current.content = append(current.content, n)
+			}
+			current = n
+		case xml.EndElement:
+			if current == nil || current.name != t.Name {
+				return nil, errors.New("Error: unexpected end element " + t.Name.Local)
+			}
+			current = current.parent
+		case xml.CharData:
+			if current != nil {
+				current.content = append(current.content, string(t))
+			}
+		}
+	}
+	if root == nil || current != nil {
+		return nil, errors.New("Error: incomplete document")
+	}
+	return root, nil
+}
+
+// lookup returns the namespace URI bound to the prefix in the scope of the
+// node ("" is the default namespace).
+func (n *node) lookup(prefix string) (string, bool) {
+	if prefix == "xml" {
+		return nsXML, true
+	}
+	for e := n; e != nil; e = e.parent {
+		for _, a := range e.attrs {
+			if (prefix == "" && a.Name.Space == "" && a.Name.Local == "xmlns") ||
+				(prefix != "" && a.Name.Space == "xmlns" && a.Name.Local == prefix) {
+				return a.Value, true
+			}
+		}
+	}
+	return "", false
+}
+
+// inScope returns every namespace binding in the scope of the node.
+func (n *node) inScope() map[string]string {
+	scope := make(map[string]string)
+	for e := n; e != nil; e = e.parent {
+		for _, a := range e.attrs {
+			prefix, ok := namespaceDecl(a)
+			if _, seen := scope[prefix]; ok && !seen {
+				scope[prefix] = a.Value
+			}
+		}
+	}
+	return scope
+}
+
+// namespaceDecl returns the prefix declared by the attribute, if it is a
+// namespace declaration.
+func namespaceDecl(a xml.Attr) (string, bool) {
+	if a.Name.Space == "" && a.Name.Local == "xmlns" {
+		return "", true
+	}
+	if a.Name.Space == "xmlns" {
+		return a.Name.Local, true
+	}
+	return "", false
+}
+
+// namespace returns the namespace URI of the node.
+func (n *node) namespace() string {
+	uri, _ := n.lookup(n.name.Space)
+	return uri
+}
+
+// children returns the child elements with the namespace and local name.
+func (n *node) children(space, local string) []*node {
+	var nodes []*node
+	for _, c := range n.content {
+		if e, ok := c.(*node); ok && e.name.Local == local && e.namespace() == space {
+			nodes = append(nodes, e)
+		}
+	}
+	return nodes
+}
+
+// child returns the first child element with the namespace and local name.
+func (n *node) child(space, local string) *node {
+	if nodes := n.children(space, local); len(nodes) > 0 {
+		return nodes[0]
+	}
+	return nil
+}
+
+// path returns the descendant reached by following the local names (all in
+// the namespace). A nil node is returned if the path does not exist.
+func (n *node) path(space string, locals ...string) *node {
+	e := n
+	for _, local := range locals {
+		if e = e.child(space, local); e == nil {
+			return nil
+		}
+	}
+	return e
+}
+
+// attr returns the value of the attribute with the prefix and local name.
+func (n *node) attr(prefix, local string) string {
+	if n == nil {
+		return ""
+	}
+	for _, a := range n.attrs {
+		if a.Name.Space == prefix && a.Name.Local == local {
+			return a.Value
+		}
+	}
+	return ""
+}
+
+// text returns the concatenated character data of the node.
+func (n *node) text() string {
+	if n == nil {
+		return ""
+	}
+	var b strings.Builder
+	for _, c := range n.content {
+		if s, ok := c.(string); ok {
+			b.WriteString(s)
+		}
+	}
+	return b.String()
+}
+
+// canonicalizer renders nodes using Canonical XML 1.0 or Exclusive Canonical
+// XML 1.0 (comments are never present in the parsed tree).
+type canonicalizer struct {
+	exclusive bool            // Indicator to use exclusive canonicalization
+	prefixes  map[string]bool // InclusiveNamespaces PrefixList (exclusive only)
+	exclude   map[*node]bool  // Subtrees omitted from the output (enveloped signatures)
+}
+
+// configure sets the canonicalization algorithm from the method element.
+func (c *canonicalizer) configure(method *node, alg string) {
+	c.exclusive = alg == algExcC14N || alg == algExcC14NComments
+	c.prefixes = nil
+	for _, e := range method.content {
+		if n, ok := e.(*node); ok && n.name.Local == "InclusiveNamespaces" {
+			c.prefixes = make(map[string]bool)
+			for _, prefix := range strings.Fields(n.attr("", "PrefixList")) {
+				if prefix == "#default" {
+					prefix = ""
+				}
+				c.prefixes[prefix] = true
+			}
+		}
+	}
+}
+
+// canonicalize writes the canonical form of the subtree rooted at the node.
+func (c *canonicalizer) canonicalize(w io.Writer, n *node) {
+	var b bytes.Buffer
+	c.render(&b, n, map[string]string{})
+	w.Write(b.Bytes())
+}
+
+// render writes the canonical form of the element. Rendered holds the
+// namespace bindings output by the ancestors of the element.
+func (c *canonicalizer) render(b *bytes.Buffer, n *node, rendered map[string]string) {
+	if c.exclude[n] {
+		return
+	}
+
+	// namespace declarations
+	scope := n.inScope()
+	candidates := make(map[string]bool)
+	if c.exclusive {
+		candidates[n.name.Space] = true
+		for _, a := range n.attrs {
+			if _, ok := namespaceDecl(a); !ok && a.Name.Space != "" && a.Name.Space != "xml" {
+				candidates[a.Name.Space] = true
+			}
+		}
+		for prefix := range c.prefixes {
+			candidates[prefix] = true
+		}
+	} else {
+		for prefix := range scope {
+			candidates[prefix] = true
+		}
+	}
+	var decls []string
+	output := make(map[string]string, len(rendered))
+	for prefix, uri := range rendered {
+		output[prefix] = uri
+	}
+	for prefix := range candidates {
+		uri, ok := scope[prefix]
+		if prefix == "" && uri == "" {
+			// an empty default namespace is only output to undeclare a
+			// rendered default namespace
+			if rendered[""] != "" {
+				decls = append(decls, "")
+				output[""] = ""
+			}
+			continue
+		}
+		if !ok {
+			continue
+		}
+		if current, ok := rendered[prefix]; !ok || current != uri {
+			decls = append(decls, prefix)
+			output[prefix] = uri
+		}
+	}
+	sort.Strings(decls)
+
+	// attributes
+	type attribute struct {
+		uri   string
+		name  string
+		value string
+	}
+	var attrs []attribute
+	for _, a := range n.attrs {
+		if _, ok := namespaceDecl(a); ok {
+			continue
+		}
+		attr := attribute{name: a.Name.Local, value: a.Value}
+		if a.Name.Space != "" {
+			attr.uri, _ = n.lookup(a.Name.Space)
+			attr.name = a.Name.Space + ":" + a.Name.Local
+		}
+		attrs = append(attrs, attr)
+	}
+	sort.Slice(attrs, func(i, j int) bool {
+		if attrs[i].uri != attrs[j].uri {
+			return attrs[i].uri < attrs[j].uri
+		}
+		return localName(attrs[i].name) < localName(attrs[j].name)
+	})
+
+	// start tag
+	name := n.name.Local
+	if n.name.Space != "" {
+		name = n.name.Space + ":" + name
+	}
+	b.WriteByte('<')
+	b.WriteString(name)
+	for _, prefix := range decls {
+		if prefix == "" {
+			b.WriteString(` xmlns="`)
+		} else {
+			b.WriteString(` xmlns:` + prefix + `="`)
+		}
+		b.WriteString(escapeAttr(output[prefix]))
+		b.WriteByte('"')
+	}
+	for _, a := range attrs {
+		b.WriteString(" " + a.name + `="` + escapeAttr(a.value) + `"`)
+	}
+	b.WriteByte('>')
+
+	// content
+	for _, child := range n.content {
+		switch v := child.(type) {
+		case *node:
+			c.render(b, v, output)
+		case string:
+			b.WriteString(escapeText(v))
+		}
+	}
+
+	// end tag
+	b.WriteString("</" + name + ">")
+}
+
+// localName returns the local part of a qualified name.
+func localName(name string) string {
+	if i := strings.IndexByte(name, ':'); i >= 0 {
+		return name[i+1:]
+	}
+	return name
+}
+
+// canonical escaping of character data and attribute values
+var (
+	textEscaper = strings.NewReplacer("&", "&amp;", "<", "&lt;", ">", "&gt;", "\r", "&#xD;")
+	attrEscaper = strings.NewReplacer("&", "&amp;", "<", "&lt;", `"`, "&quot;", "\t", "&#x9;", "\n", "&#xA;", "\r", "&#xD;")
+)
+
+// escapeText escapes character data for canonical output.
+func escapeText(val string) string {
+	return textEscaper.Replace(val)
+}
+
+// escapeAttr escapes an attribute value for canonical output.
+func escapeAttr(val string) string {
+	return attrEscaper.Replace(val)
+}
@@ -0,0 +1,125 @@
+// Copyright (c) 2019 Tanner Ryan. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package naads
+
+import (
+	"bytes"
+	"io/ioutil"
+	"path/filepath"
+	"testing"
+)
+
+// signedAlert is a NAADS message signed by Pelmorex and Environment Canada.
+const signedAlert = "PelmorexNAADS_WindWarning.xml"
+
+// signedC14N and signedExcC14N are documents with namespaced attributes,
+// signed by .NET SignedXml with a self-signed certificate using inclusive
+// C14N with RSA-SHA1 and exclusive C14N with RSA-SHA256 respectively.
+const (
+	signedC14N    = "signed-c14n.xml"
+	signedExcC14N = "signed-exc-c14n.xml"
+)
+
+// noteTag is the start tag of the note element of the .NET signed documents.
+const noteTag = `<note ex:lang="en" ex:quote="say &quot;hi&quot;" ex:amp="a &amp; b">`
+
+// readTestdata returns the contents of the file in the testdata directory.
+func readTestdata(t *testing.T, name string) []byte {
+	t.Helper()
+	data, err := ioutil.ReadFile(filepath.Join("testdata", name))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return data
+}
+
+func TestVerifySignature(t *testing.T) {
+	tests := []struct {
+		name   string
+		signer string
+	}{
+		{signedAlert, "dss1.pelmorex.com"},
+		{signedC14N, "naads-test"},
+		{signedExcC14N, "naads-test"},
+	}
+	for _, test := range tests {
+		v := VerifySignature(readTestdata(t, test.name))
+		if !v.Valid {
+			t.Errorf("signature of %s is invalid: %s", test.name, v.Err)
+			continue
+		}
+		if v.Certificate == nil || v.Certificate.Subject.CommonName != test.signer {
+			t.Errorf("%s: unexpected signing certificate %v", test.name, v.Certificate)
+		}
+	}
+}
+
+func TestVerifySignatureCanonical(t *testing.T) {
+	// changes removed by canonicalization keep the signature valid
+	reordered := []byte(`<note ex:amp='a &amp; b' ex:lang="en"  ex:quote='say "hi"'>`)
+	unused := []byte(`<ex:extension ex:id="1" xmlns:unused="urn:example:unused">`)
+	tests := []struct {
+		name  string
+		file  string
+		old   []byte
+		new   []byte
+		valid bool
+	}{
+		{"attribute order and quoting", signedC14N, []byte(noteTag), reordered, true},
+		{"attribute order and quoting", signedExcC14N, []byte(noteTag), reordered, true},
+		// unused namespace declarations are only omitted by exclusive C14N
+		{"unused namespace", signedC14N, unused, []byte(`<ex:extension ex:id="1">`), false},
+		{"unused namespace", signedExcC14N, unused, []byte(`<ex:extension ex:id="1">`), true},
+	}
+	for _, test := range tests {
+		raw := readTestdata(t, test.file)
+		modified := bytes.Replace(raw, test.old, test.new, 1)
+		if bytes.Equal(modified, raw) {
+			t.Errorf("%s (%s): document was not modified", test.name, test.file)
+			continue
+		}
+		if v := VerifySignature(modified); v.Valid != test.valid {
+			t.Errorf("%s (%s): got valid %t, want %t (%v)", test.name, test.file, v.Valid, test.valid, v.Err)
+		}
+	}
+}
+
+func TestVerifySignatureTampered(t *testing.T) {
+	raw := readTestdata(t, signedAlert)
+
+	// the Pelmorex signature, moved from the root into the first info block
+	sigStart := bytes.Index(raw, []byte(`<Signature Id="NAADS Signature"`))
+	sigEnd := bytes.Index(raw[sigStart:], []byte("</Signature>")) + sigStart + len("</Signature>")
+	sig := append([]byte(nil), raw[sigStart:sigEnd]...)
+	moved := append(append([]byte(nil), raw[:sigStart]...), raw[sigEnd:]...)
+	moved = bytes.Replace(moved, []byte("<info>"), append([]byte("<info>"), sig...), 1)
+
+	attr := readTestdata(t, signedExcC14N)
+	tests := []struct {
+		name string
+		raw  []byte
+	}{
+		{"text node", bytes.Replace(raw, []byte("<status>Actual</status>"), []byte("<status>Test</status>"), 1)},
+		{"whitespace", bytes.Replace(raw, []byte("<status>Actual</status>"), []byte("<status>Actual </status>"), 1)},
+		{"namespace attribute", bytes.Replace(raw, []byte(`xmlns="urn:oasis:names:tc:emergency:cap:1.2"`), []byte(`xmlns="urn:oasis:names:tc:emergency:cap:1.1"`), 1)},
+		{"signed info attribute", bytes.Replace(raw, []byte(`<CanonicalizationMethod Algorithm="http://www.w3.org/TR/2001/REC-xml-c14n-20010315" />`), []byte(`<CanonicalizationMethod Algorithm="http://www.w3.org/2001/10/xml-exc-c14n#" />`), 1)},
+		{"added element", bytes.Replace(raw, []byte("<info>"), []byte("<info><web>https://example.com</web>"), 1)},
+		{"moved signature", moved},
+		{"reference URI", bytes.Replace(raw, []byte(`<Reference URI="">`), []byte(`<Reference URI="#alert">`), 1)},
+		{"signature value", bytes.Replace(raw, []byte("<SignatureValue>Pj4l"), []byte("<SignatureValue>Qj4l"), 1)},
+		{"attribute value", bytes.Replace(attr, []byte(`ex:lang="en"`), []byte(`ex:lang="fr"`), 1)},
+		{"attribute namespace", bytes.Replace(attr, []byte(`xmlns:ex="urn:example:naads"`), []byte(`xmlns:ex="urn:example:other"`), 1)},
+		{"added attribute", bytes.Replace(attr, []byte(noteTag), []byte(noteTag[:len(noteTag)-1]+` ex:added="1">`), 1)},
+	}
+	for _, test := range tests {
+		if bytes.Equal(test.raw, raw) || bytes.Equal(test.raw, attr) {
+			t.Errorf("%s: document was not modified", test.name)
+			continue
+		}
+		if v := VerifySignature(test.raw); v.Valid {
+			t.Errorf("%s: tampered document verified", test.name)
+		}
+	}
+}